@@ -1,7 +1,12 @@
 package cennz
 
 import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/blocktree/go-owcdrivers/owkeychain"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
@@ -52,6 +57,53 @@ func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (st
 	return result, nil
 }
 
+//SupportCustomCreateAddressFunction 使用 sr25519 签名时由 ed25519 公钥转换出 sr25519 公钥再生成地址
+func (dec *AddressDecoderV2) SupportCustomCreateAddressFunction() bool {
+	return dec.wm != nil && dec.wm.Config.SignatureType == cennzTransaction.SignatureTypeSr25519
+}
+
+//CustomCreateAddress 创建 sr25519 地址，地址记录的公钥为 sr25519 公钥
+func (dec *AddressDecoderV2) CustomCreateAddress(account *openwallet.AssetsAccount, newIndex uint64) (*openwallet.Address, error) {
+	if len(account.OwnerKeys) == 0 || len(account.HDPath) == 0 {
+		return nil, fmt.Errorf("account %s has no owner key or hdPath", account.AccountID)
+	}
+
+	ownerKey, err := owkeychain.OWDecode(account.OwnerKeys[0])
+	if err != nil {
+		return nil, err
+	}
+	start, err := ownerKey.GenPublicChild(0)
+	if err != nil {
+		return nil, err
+	}
+	childKey, err := start.GenPublicChild(uint32(newIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := cennzTransaction.GetSr25519PublicKey(childKey.GetPublicKeyBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := dec.AddressEncode(pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openwallet.Address{
+		AccountID: account.AccountID,
+		Symbol:    account.Symbol,
+		Index:     newIndex,
+		Address:   address,
+		Balance:   "0",
+		WatchOnly: false,
+		PublicKey: hex.EncodeToString(pubkey),
+		HDPath:    fmt.Sprintf("%s/%d/%d", account.HDPath, 0, newIndex),
+		IsChange:  false,
+	}, nil
+}
+
 // AddressVerify 地址校验
 func (dec *AddressDecoderV2) AddressVerify(address string, opts ...interface{}) bool {
 	P2PKHPrefix := byte( dec.wm.AddrPrefix() )
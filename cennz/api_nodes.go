@@ -0,0 +1,199 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+const (
+	//节点请求失败后暂停使用的时间，连续失败时翻倍
+	nodeRetryMinWait = 10 * time.Second
+	nodeRetryMaxWait = 5 * time.Minute
+)
+
+var errNoNode = errors.New("no node configured")
+
+// apiNode 一个节点，记录节点的可用状态
+type apiNode struct {
+	backend ChainBackend
+
+	lock        sync.Mutex
+	failures    int
+	pausedUntil time.Time
+}
+
+//name 日志中显示的节点
+func (n *apiNode) name() string {
+	if s, ok := n.backend.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", n.backend)
+}
+
+func (n *apiNode) healthy() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return time.Now().After(n.pausedUntil)
+}
+
+func (n *apiNode) succeed() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.failures = 0
+	n.pausedUntil = time.Time{}
+}
+
+func (n *apiNode) fail(err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	wait := nodeRetryMinWait
+	for i := 0; i < n.failures && wait < nodeRetryMaxWait; i++ {
+		wait *= 2
+	}
+	if wait > nodeRetryMaxWait {
+		wait = nodeRetryMaxWait
+	}
+	n.failures++
+	n.pausedUntil = time.Now().Add(wait)
+
+	log.Warning("node ", n.name(), " failed, pause ", wait, ", error : ", err)
+}
+
+//splitEndpoints 解析逗号分隔的节点地址
+func splitEndpoints(urls string) []string {
+	endpoints := make([]string, 0)
+	for _, url := range strings.Split(urls, ",") {
+		url = strings.TrimSpace(url)
+		if len(url) > 0 {
+			endpoints = append(endpoints, url)
+		}
+	}
+	return endpoints
+}
+
+//endpointAt 第 i 个节点使用的地址，只配置了一个地址时所有节点共用
+func endpointAt(endpoints []string, i int) string {
+	if len(endpoints) == 0 {
+		return ""
+	}
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+	return endpoints[i]
+}
+
+//countNodes 节点数量，多个地址列表的长度必须一致或只有一个地址
+func countNodes(lists ...[]string) (int, error) {
+	count := 1
+	for _, list := range lists {
+		if len(list) <= 1 {
+			continue
+		}
+		if count > 1 && len(list) != count {
+			return 0, fmt.Errorf("endpoint lists have different length, %d and %d", count, len(list))
+		}
+		count = len(list)
+	}
+	return count, nil
+}
+
+//pickNodes 按轮询顺序返回节点，可用的节点在前，暂停的节点作为最后的选择
+func (c *ApiClient) pickNodes() []*apiNode {
+	count := len(c.nodes)
+	if count == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint32(&c.nextNode, 1)) % count
+
+	healthy := make([]*apiNode, 0, count)
+	paused := make([]*apiNode, 0)
+	for i := 0; i < count; i++ {
+		node := c.nodes[(start+i)%count]
+		if node.healthy() {
+			healthy = append(healthy, node)
+		} else {
+			paused = append(paused, node)
+		}
+	}
+
+	return append(healthy, paused...)
+}
+
+//do 依次在节点上执行请求直到成功，节点返回的错误说明节点可用，直接返回不再切换
+func (c *ApiClient) do(fn func(node *apiNode) error) error {
+	err := errNoNode
+	for _, node := range c.pickNodes() {
+		err = fn(node)
+		if err == nil || isRpcError(err) {
+			node.succeed()
+			return err
+		}
+		node.fail(err)
+	}
+	return err
+}
+
+//quorum 在多个节点上执行请求，至少 c.Quorum 个节点的结果一致才采用，fn 返回用于比较的 key 和结果
+func (c *ApiClient) quorum(fn func(node *apiNode) (string, interface{}, error)) (interface{}, error) {
+	if c.Quorum <= 1 {
+		var result interface{}
+		err := c.do(func(node *apiNode) error {
+			_, r, err := fn(node)
+			result = r
+			return err
+		})
+		return result, err
+	}
+
+	var (
+		lastErr = errNoNode
+		votes   = make(map[string]int)
+	)
+	for _, node := range c.pickNodes() {
+		key, result, err := fn(node)
+		if err != nil {
+			if isRpcError(err) {
+				node.succeed()
+			} else {
+				node.fail(err)
+			}
+			lastErr = err
+			continue
+		}
+		node.succeed()
+
+		votes[key]++
+		if votes[key] >= c.Quorum {
+			return result, nil
+		}
+	}
+
+	if len(votes) > 0 {
+		return nil, fmt.Errorf("quorum of %d nodes not reached, results : %v", c.Quorum, votes)
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,44 @@
+package cennz
+
+import (
+	"testing"
+)
+
+func Test_countNodes(t *testing.T) {
+	rpcAPIs := splitEndpoints("http://a:9933, http://b:9933 ,")
+	balanceAPIs := splitEndpoints("http://c:8080")
+
+	count, err := countNodes(nil, balanceAPIs, rpcAPIs)
+	if err != nil || count != 2 {
+		t.Errorf("countNodes failed, count=%d, err=%v", count, err)
+		return
+	}
+
+	if endpointAt(rpcAPIs, 1) != "http://b:9933" || endpointAt(balanceAPIs, 1) != "http://c:8080" {
+		t.Errorf("endpointAt failed")
+	}
+
+	_, err = countNodes(splitEndpoints("http://a,http://b,http://c"), rpcAPIs)
+	if err == nil {
+		t.Errorf("countNodes should fail with different length")
+	}
+}
+
+func TestNewApiClientRequiresBalanceAPI(t *testing.T) {
+	wm := NewWalletManager()
+	wm.Config.APIChoose = APIClientAllRpcMode
+	wm.Config.RpcAPI = "http://127.0.0.1:1"
+	wm.Config.BalanceAPI = ""
+
+	err := NewApiClient(wm)
+	if err == nil || wm.ApiClient != nil {
+		t.Errorf("NewApiClient should refuse allRpc without balanceAPI, err=%v", err)
+		return
+	}
+
+	wm.Config.BalanceAPI = "http://127.0.0.1:2"
+	err = NewApiClient(wm)
+	if err != nil || wm.ApiClient == nil {
+		t.Errorf("NewApiClient failed, err=%v", err)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// ChainBackend 获取链上数据和广播交易的方式，每个节点对应一个实例
+type ChainBackend interface {
+	//最新区块高度
+	GetBlockHeight() (uint64, error)
+	//指定高度的区块hash
+	GetBlockHash(height uint64) (string, error)
+	//指定高度的区块，包含解析出的转账
+	GetBlockByHeight(height uint64) (*Block, error)
+	//已确认的最新区块hash
+	GetFinalizedHead() (string, error)
+	//已确认的最新区块，只有高度和hash
+	GetFinalizedBlock() (*Block, error)
+	//地址在指定区块的余额和nonce，blockHash 为空时为最新区块
+	GetBalance(address, assetId, blockHash string) (*AddrBalance, error)
+	//地址在指定区块的nonce，blockHash 为空时为最新区块
+	GetNonce(address, blockHash string) (uint64, error)
+	//指定区块的运行时版本，blockHash 为空时为最新区块
+	GetRuntimeVersion(blockHash string) (*RuntimeVersion, error)
+	//指定区块的元数据，16进制
+	GetMetadata(blockHash string) (string, error)
+	//创世区块hash
+	GetGenesisHash() (string, error)
+	//广播交易，返回txid
+	SendTransaction(rawTx string) (string, error)
+	//查询交易的手续费，返回 partialFee
+	QueryFeeInfo(extrinsic string) (*big.Int, error)
+	//交易池中等待打包的交易单
+	GetPendingExtrinsics() ([]string, error)
+}
+
+// SubscribeBackend 支持订阅节点推送的 backend
+type SubscribeBackend interface {
+	//当前连接是否支持订阅
+	SupportSubscribe() bool
+	Subscribe(method, unsubscribeMethod string, params []interface{}) (*Subscription, error)
+}
+
+// BackendCreator 按配置为每个节点创建一个 backend
+type BackendCreator func(config *WalletConfig) ([]ChainBackend, error)
+
+var (
+	backendsLock sync.RWMutex
+	backends     = make(map[string]BackendCreator)
+)
+
+// RegisterBackend 注册 backend，配置 apiChoose = name 时使用，可以覆盖内置的 http、allRpc、ws
+func RegisterBackend(name string, creator BackendCreator) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	backends[name] = creator
+}
+
+func getBackendCreator(name string) (BackendCreator, bool) {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	creator, ok := backends[name]
+	return creator, ok
+}
+
+func init() {
+	RegisterBackend(APIClientHttpMode, newExplorerBackends)
+	RegisterBackend(APIClientAllRpcMode, newBalanceBackends)
+	RegisterBackend(APIClientWSMode, newBalanceBackends)
+}
+
+//newExplorerBackends nodeAPI、balanceAPI、rpcAPI 按顺序组成节点
+func newExplorerBackends(config *WalletConfig) ([]ChainBackend, error) {
+	nodeAPIs := splitEndpoints(config.NodeAPI)
+	balanceAPIs := splitEndpoints(config.BalanceAPI)
+	rpcAPIs := splitEndpoints(config.RpcAPI)
+
+	count, err := countNodes(nodeAPIs, balanceAPIs, rpcAPIs)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeIndexes := newRuntimeIndexesCache()
+	result := make([]ChainBackend, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, &explorerBackend{
+			nodeBackend: &nodeBackend{
+				rpc:            NewRpcClient(endpointAt(rpcAPIs, i), false, config.Symbol),
+				runtimeIndexes: runtimeIndexes,
+			},
+			explorer: NewClient(endpointAt(nodeAPIs, i), false, config.Symbol),
+			balance:  NewBalanceClient(endpointAt(balanceAPIs, i), false, config.Symbol),
+		})
+	}
+
+	return result, nil
+}
+
+//newBalanceBackends balanceAPI 和 rpcAPI（ws 模式为 wsAPI）按顺序组成节点，节点接口不能解析区块，必须配置 balanceAPI
+func newBalanceBackends(config *WalletConfig) ([]ChainBackend, error) {
+	isWS := config.APIChoose == APIClientWSMode
+
+	balanceAPIs := splitEndpoints(config.BalanceAPI)
+	if len(balanceAPIs) == 0 {
+		return nil, fmt.Errorf("balanceAPI is required for block scanning in %s mode", config.APIChoose)
+	}
+	rpcAPIs := splitEndpoints(config.RpcAPI)
+	if isWS {
+		rpcAPIs = splitEndpoints(config.WSAPI)
+	}
+
+	count, err := countNodes(balanceAPIs, rpcAPIs)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeIndexes := newRuntimeIndexesCache()
+	result := make([]ChainBackend, 0, count)
+	for i := 0; i < count; i++ {
+		node := &nodeBackend{
+			runtimeIndexes: runtimeIndexes,
+		}
+		if isWS {
+			node.rpc = NewWSRpcClient(endpointAt(rpcAPIs, i), false, config.Symbol)
+		} else {
+			node.rpc = NewRpcClient(endpointAt(rpcAPIs, i), false, config.Symbol)
+		}
+
+		result = append(result, &balanceBackend{
+			nodeBackend: node,
+			balance:     NewBalanceClient(endpointAt(balanceAPIs, i), false, config.Symbol),
+		})
+	}
+
+	return result, nil
+}
+
+// nodeBackend 直接访问节点的 JSON-RPC，余额和nonce读取链上存储，不能解析区块
+type nodeBackend struct {
+	rpc            *RpcClient
+	runtimeIndexes *runtimeIndexesCache
+}
+
+func (b *nodeBackend) String() string {
+	return b.rpc.BaseURL
+}
+
+func (b *nodeBackend) GetBlockHeight() (uint64, error) {
+	return b.rpc.getBlockHeight()
+}
+
+func (b *nodeBackend) GetBlockHash(height uint64) (string, error) {
+	return b.rpc.GetBlockHash(height)
+}
+
+func (b *nodeBackend) GetBlockByHeight(height uint64) (*Block, error) {
+	return nil, errors.New("raw node backend can not decode blocks, balanceAPI is required for block scanning")
+}
+
+func (b *nodeBackend) GetFinalizedHead() (string, error) {
+	return b.rpc.GetFinalizedHead()
+}
+
+func (b *nodeBackend) GetFinalizedBlock() (*Block, error) {
+	return b.rpc.getFinalizedBlock()
+}
+
+func (b *nodeBackend) GetBalance(address, assetId, blockHash string) (*AddrBalance, error) {
+	indexes, err := b.runtimeIndexes.getAt(b, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.rpc.getStorageBalance(indexes.Metadata, address, blockHash, assetId)
+}
+
+func (b *nodeBackend) GetNonce(address, blockHash string) (uint64, error) {
+	balance, err := b.GetBalance(address, "", blockHash)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Nonce, nil
+}
+
+func (b *nodeBackend) GetRuntimeVersion(blockHash string) (*RuntimeVersion, error) {
+	return b.rpc.GetRuntimeVersionAt(blockHash)
+}
+
+func (b *nodeBackend) GetMetadata(blockHash string) (string, error) {
+	return b.rpc.GetMetadata(blockHash)
+}
+
+func (b *nodeBackend) GetGenesisHash() (string, error) {
+	return b.rpc.GetGenesisHash()
+}
+
+func (b *nodeBackend) SendTransaction(rawTx string) (string, error) {
+	return b.rpc.sendTransaction(rawTx)
+}
+
+func (b *nodeBackend) QueryFeeInfo(extrinsic string) (*big.Int, error) {
+	return b.rpc.QueryFeeInfo(extrinsic)
+}
+
+func (b *nodeBackend) GetPendingExtrinsics() ([]string, error) {
+	return b.rpc.GetPendingExtrinsics()
+}
+
+func (b *nodeBackend) GetHealth() (*NodeHealth, error) {
+	return b.rpc.GetHealth()
+}
+
+func (b *nodeBackend) SupportSubscribe() bool {
+	return b.rpc.ws != nil
+}
+
+func (b *nodeBackend) Subscribe(method, unsubscribeMethod string, params []interface{}) (*Subscription, error) {
+	return b.rpc.Subscribe(method, unsubscribeMethod, params)
+}
+
+// balanceBackend 区块和余额来自余额接口，其他数据来自节点的 JSON-RPC
+type balanceBackend struct {
+	*nodeBackend
+	balance *BalanceApiClient
+}
+
+//GetBlockByHeight 获取区块后，通过一次批量请求核对区块hash并获取区块所在的运行时
+func (b *balanceBackend) GetBlockByHeight(height uint64) (*Block, error) {
+	raw, err := b.balance.getRawBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	blockHash := raw.Get("hash").String()
+	if len(blockHash) == 0 {
+		return nil, fmt.Errorf("block hash not found, height : %d", height)
+	}
+
+	info, err := b.rpc.getBlockInfo(height, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Hash != blockHash {
+		return nil, errors.New("wrong block, rpc :" + info.Hash + ", balance api : " + blockHash)
+	}
+
+	//按区块所在的运行时解析事件序号
+	indexes, err := b.runtimeIndexes.get(b, info.RuntimeVersion, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := NewBlockFromRpc(raw, b.balance.Symbol, indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	//余额接口还未标记时，以节点的已确认区块为准
+	if block.Hash == info.FinalizedHead {
+		block.Finalized = true
+	}
+
+	return block, nil
+}
+
+func (b *balanceBackend) GetBalance(address, assetId, blockHash string) (*AddrBalance, error) {
+	return b.balance.getApiBalanceWithNonce(address, blockHash, assetId)
+}
+
+func (b *balanceBackend) GetNonce(address, blockHash string) (uint64, error) {
+	balance, err := b.GetBalance(address, "", blockHash)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Nonce, nil
+}
+
+// explorerBackend 区块高度、区块和nonce来自浏览器接口，余额来自余额接口，其他数据来自节点的 JSON-RPC
+type explorerBackend struct {
+	*nodeBackend
+	explorer *Client
+	balance  *BalanceApiClient
+}
+
+func (b *explorerBackend) String() string {
+	return b.explorer.BaseURL
+}
+
+func (b *explorerBackend) GetBlockHeight() (uint64, error) {
+	return b.explorer.getBlockHeight()
+}
+
+//GetBlockByHeight 浏览器接口的区块需要与节点的区块hash一致
+func (b *explorerBackend) GetBlockByHeight(height uint64) (*Block, error) {
+	block, err := b.explorer.getBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	hashInRpc, err := b.rpc.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+
+	if hashInRpc != block.Hash {
+		return nil, errors.New("wrong block, rpc :" + hashInRpc + ", http : " + block.Hash)
+	}
+
+	return block, nil
+}
+
+func (b *explorerBackend) GetBalance(address, assetId, blockHash string) (*AddrBalance, error) {
+	balance, err := b.explorer.getBalance(address, assetId)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.balance.getApiBalance(balance, blockHash)
+}
+
+func (b *explorerBackend) GetNonce(address, blockHash string) (uint64, error) {
+	balance, err := b.explorer.getBalance(address, "")
+	if err != nil {
+		return 0, err
+	}
+	return balance.Nonce, nil
+}
@@ -0,0 +1,156 @@
+package cennz
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+//testChainBackend 内存中的链数据，用于不连接节点的单元测试
+type testChainBackend struct {
+	lock sync.Mutex
+
+	height      uint64
+	blocks      map[uint64]*Block
+	specVersion uint32
+
+	fee        *big.Int
+	feeQueries []string
+	pending    []string
+
+	heightCalls int
+	delays      map[uint64]time.Duration //获取区块的延迟，模拟节点响应顺序不同
+	health      *NodeHealth              //为空时节点正常
+}
+
+func newTestChainBackend() *testChainBackend {
+	return &testChainBackend{
+		blocks:      make(map[uint64]*Block),
+		specVersion: 37,
+	}
+}
+
+//newTestApiClient 使用 backend 创建 ApiClient，运行时序号预先放入缓存
+func newTestApiClient(backend ChainBackend, indexes *RuntimeIndexes) *ApiClient {
+	client := &ApiClient{
+		APIChoose:      "test",
+		nodes:          []*apiNode{{backend: backend}},
+		runtimeIndexes: newRuntimeIndexesCache(),
+	}
+	if indexes != nil {
+		client.runtimeIndexes.indexes[indexes.SpecVersion] = indexes
+	}
+	return client
+}
+
+func (b *testChainBackend) setBlock(block *Block) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.blocks[block.Height] = block
+	if block.Height > b.height {
+		b.height = block.Height
+	}
+}
+
+func (b *testChainBackend) GetBlockHeight() (uint64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.heightCalls++
+	return b.height, nil
+}
+
+func (b *testChainBackend) GetBlockHash(height uint64) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	block, ok := b.blocks[height]
+	if !ok {
+		return "", errors.New("block not found")
+	}
+	return block.Hash, nil
+}
+
+func (b *testChainBackend) GetBlockByHeight(height uint64) (*Block, error) {
+	b.lock.Lock()
+	delay := b.delays[height]
+	b.lock.Unlock()
+	time.Sleep(delay)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	block, ok := b.blocks[height]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	copied := *block
+	return &copied, nil
+}
+
+func (b *testChainBackend) GetFinalizedHead() (string, error) {
+	block, err := b.GetFinalizedBlock()
+	if err != nil {
+		return "", err
+	}
+	return block.Hash, nil
+}
+
+func (b *testChainBackend) GetFinalizedBlock() (*Block, error) {
+	height, _ := b.GetBlockHeight()
+	return b.GetBlockByHeight(height)
+}
+
+func (b *testChainBackend) GetBalance(address, assetId, blockHash string) (*AddrBalance, error) {
+	return nil, errors.New("not supported")
+}
+
+func (b *testChainBackend) GetNonce(address, blockHash string) (uint64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (b *testChainBackend) GetRuntimeVersion(blockHash string) (*RuntimeVersion, error) {
+	return &RuntimeVersion{SpecVersion: b.specVersion, TransactionVersion: 5}, nil
+}
+
+func (b *testChainBackend) GetMetadata(blockHash string) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (b *testChainBackend) GetGenesisHash() (string, error) {
+	return "0x0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0", nil
+}
+
+func (b *testChainBackend) SendTransaction(rawTx string) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (b *testChainBackend) QueryFeeInfo(extrinsic string) (*big.Int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.feeQueries = append(b.feeQueries, extrinsic)
+	if b.fee == nil {
+		return nil, errors.New("fee is not available")
+	}
+	return new(big.Int).Set(b.fee), nil
+}
+
+func (b *testChainBackend) GetPendingExtrinsics() ([]string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.pending, nil
+}
+
+func (b *testChainBackend) GetHealth() (*NodeHealth, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.health == nil {
+		return &NodeHealth{}, nil
+	}
+	return b.health, nil
+}
@@ -169,14 +169,19 @@ func (c *BalanceApiClient) getApiBalanceWithNonce(address, blockHash, assetId st
 }
 
 // 获取地址余额
-func (c *BalanceApiClient) getBlockByHeight(height uint64) (*Block, error) {
-	url := "/block/getblock?height=" + strconv.FormatUint(height, 10)
-
-	r, err := c.BalanceApiGetCall(url);
+func (c *BalanceApiClient) getBlockByHeight(height uint64, indexes *RuntimeIndexes) (*Block, error) {
+	r, err := c.getRawBlockByHeight(height)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return NewBlockFromRpc(r, c.Symbol)
+	return NewBlockFromRpc(r, c.Symbol, indexes)
+}
+
+// 获取未解析的区块内容，解析前需要先确定区块所在的运行时
+func (c *BalanceApiClient) getRawBlockByHeight(height uint64) (*gjson.Result, error) {
+	url := "/block/getblock?height=" + strconv.FormatUint(height, 10)
+
+	return c.BalanceApiGetCall(url)
 }
\ No newline at end of file
@@ -16,11 +16,19 @@
 package cennz
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/pborman/uuid"
+	"github.com/tidwall/gjson"
 )
 
 // func TestONTBlockScanner_GetCurrentBlockHeight(t *testing.T) {
@@ -70,13 +78,153 @@ func TestGetTransaction(t *testing.T) {
 	// }
 }
 
+//newTestPendingExtrinsic 构造交易池中的转账，签名不参与解析，使用空签名
+func newTestPendingExtrinsic(t *testing.T, sender string, nonce uint64, transfers ...cennzTransaction.TransferItem) string {
+	tx := cennzTransaction.TxStruct{
+		SenderPubkey:  sender,
+		Nonce:         nonce,
+		BlockHeight:   100,
+		Period:        64,
+		SignatureType: cennzTransaction.SignatureTypeEd25519,
+	}
+	if len(transfers) == 1 {
+		tx.RecipientPubkey = transfers[0].RecipientPubkey
+		tx.Amount = transfers[0].Amount
+		tx.AssetId = transfers[0].AssetId
+	} else {
+		tx.Transfers = transfers
+		tx.BatchCallIndex = "0102"
+	}
+
+	signed, err := tx.GetSignedTransaction(cennzTransaction.Generic_Asset_Transfer, strings.Repeat("00", 64))
+	if err != nil || len(signed) == 0 {
+		t.Fatal("create pending extrinsic failed : ", err)
+	}
+	return signed
+}
+
 func TestGetTxIDsInMemPool(t *testing.T) {
-	txids, err := tw.GetTxIDsInMemPool()
+	var (
+		watchedPub = strings.Repeat("11", 32)
+		otherPub   = strings.Repeat("22", 32)
+		senderPub  = strings.Repeat("33", 32)
+	)
+
+	dir, err := ioutil.TempDir("", "cennz-mempool")
 	if err != nil {
-		t.Errorf("GetTxIDsInMemPool failed unexpected error: %v\n", err)
-		return
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	indexes := testRuntimeIndexes()
+	indexes.BatchCall = "0102"
+
+	backend := newTestChainBackend()
+	wm := NewWalletManager()
+	wm.Config.dbPath = dir
+	wm.ApiClient = newTestApiClient(backend, indexes)
+
+	watchedPubBytes, _ := hex.DecodeString(watchedPub)
+	watched, _ := wm.Decoder.AddressEncode(watchedPubBytes)
+
+	backend.pending = []string{
+		//入账到监听地址
+		newTestPendingExtrinsic(t, senderPub, 1, cennzTransaction.TransferItem{RecipientPubkey: watchedPub, Amount: big.NewInt(1000), AssetId: 1}),
+		//与监听地址无关
+		newTestPendingExtrinsic(t, senderPub, 2, cennzTransaction.TransferItem{RecipientPubkey: otherPub, Amount: big.NewInt(2000), AssetId: 1}),
+		//监听地址出账，交易池只通知入账
+		newTestPendingExtrinsic(t, watchedPub, 3, cennzTransaction.TransferItem{RecipientPubkey: otherPub, Amount: big.NewInt(3000), AssetId: 1}),
+		//批量转账中只有一笔入账到监听地址
+		newTestPendingExtrinsic(t, senderPub, 4,
+			cennzTransaction.TransferItem{RecipientPubkey: otherPub, Amount: big.NewInt(4000), AssetId: 1},
+			cennzTransaction.TransferItem{RecipientPubkey: watchedPub, Amount: big.NewInt(5000), AssetId: 2},
+		),
+		//无法解析的交易被跳过
+		"0x0102",
+	}
+
+	txs, err := wm.GetTxIDsInMemPool()
+	if err != nil {
+		t.Fatalf("GetTxIDsInMemPool failed unexpected error: %v\n", err)
+	}
+	if len(txs) != 4 {
+		t.Fatal("wrong pending transactions : ", len(txs))
+	}
+	for _, tx := range txs {
+		if tx.Status != TxStatusPending {
+			t.Error("wrong pending transaction status : ", tx.TxID, tx.Status)
+		}
+	}
+
+	bs := wm.Blockscanner
+	defer bs.CloseBlockScanner()
+	bs.SetBlockScanTargetFuncV2(func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		return openwallet.ScanTargetResult{SourceKey: "account", Exist: target.ScanTarget == watched}
+	})
+	observer := newTestScanObserver()
+	bs.AddObserver(observer)
+
+	bs.ScanTxMemPool()
+
+	amounts := make(map[string]bool)
+	for _, data := range observer.data {
+		if data.Transaction.Status != TxStatusPending {
+			t.Error("wrong notified status : ", data.Transaction.TxID, data.Transaction.Status)
+		}
+		if len(data.TxInputs) != 0 {
+			t.Error("pending transaction notified inputs : ", data.Transaction.TxID)
+		}
+		for _, output := range data.TxOutputs {
+			if output.Address != watched {
+				t.Error("output to unwatched address notified : ", output.Address)
+			}
+			amounts[output.Amount] = true
+		}
+	}
+	if len(observer.data) != 2 || !amounts["1000"] || !amounts["5000"] {
+		t.Fatal("wrong pending deposits : ", len(observer.data), amounts)
+	}
+
+	//已通知的交易不再重复通知
+	observer.data = nil
+	bs.ScanTxMemPool()
+	if len(observer.data) != 0 {
+		t.Error("pending transactions notified twice : ", len(observer.data))
+	}
+}
+
+func TestScanBlockTaskPausedWhenUnhealthy(t *testing.T) {
+	bs, backend, dai, cleanup := newTestBlockScanner(t, 30, 10, 11)
+	defer cleanup()
+
+	dai.current = &openwallet.BlockHeader{Height: 10, Hash: testBlockHash(10, "aa")}
+	bs.Scanning = true
+	backend.health = &NodeHealth{IsSyncing: true, CurrentBlock: 10, HighestBlock: 30}
+
+	//订阅推送的区块头同样经过节点状态检查
+	pushHead := func() {
+		ch := make(chan *gjson.Result, 1)
+		header := gjson.Parse(`{"number":"0x1e"}`)
+		ch <- &header
+		close(ch)
+		bs.receiveFinalizedHeads(&Subscription{C: ch, ch: ch}, make(chan struct{}))
+	}
+
+	pushHead()
+	if dai.current.Height != 10 {
+		t.Fatal("block scanned while node is unhealthy : ", dai.current.Height)
+	}
+
+	//节点恢复后继续扫描
+	backend.lock.Lock()
+	backend.health = nil
+	backend.lock.Unlock()
+	bs.wm.ApiClient.healthCheckedAt = time.Time{}
+
+	pushHead()
+	if dai.current.Height != 30 {
+		t.Error("block is not scanned after node recovered : ", dai.current.Height)
 	}
-	t.Logf("GetTxIDsInMemPool = %v \n", txids)
 }
 
 // func TestONTBlockScanner_scanning(t *testing.T) {
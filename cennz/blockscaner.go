@@ -18,10 +18,12 @@ package cennz
 import (
 	"errors"
 	"fmt"
-	"github.com/asdine/storm"
 	"github.com/blocktree/openwallet/log"
+	"github.com/blocktree/openwallet/v2/common"
+	"math/big"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
@@ -30,6 +32,10 @@ import (
 
 const (
 	maxExtractingSize = 20 //并发的扫描线程数
+	//超过该时间没有收到新的已确认区块头，认为订阅中断，恢复轮询
+	finalizedHeadsTimeout = 30 * time.Second
+	//订阅失败后重试的等待时间
+	finalizedHeadsRetryWait = 10 * time.Second
 )
 
 var tokenMap = make(map[string]openwallet.SmartContract)
@@ -46,6 +52,13 @@ type CENNZBlockScanner struct {
 	RescanLastBlockCount uint64         //重扫上N个区块数量
 	//socketIO             *gosocketio.Client //socketIO客户端
 	RPCServer int
+
+	scanLock          sync.Mutex //推送和轮询不能同时扫描
+	headsLock         sync.Mutex
+	lastFinalizedHead time.Time       //最近一次收到已确认区块头的时间
+	headsQuit         chan struct{}   //停止订阅
+	memPoolNotified   map[string]bool //已通知的交易池交易
+	extractedLock     sync.Mutex      //区块提取记录
 }
 
 type ExtractOutput map[string][]*openwallet.TxOutPut
@@ -104,8 +117,28 @@ func (bs *CENNZBlockScanner) SetRescanBlockHeight(height uint64) error {
 	return nil
 }
 
-//ScanBlockTask 扫描任务
+//ScanBlockTask 扫描任务，订阅已确认区块头正常时由推送触发扫描，订阅中断后恢复轮询
 func (bs *CENNZBlockScanner) ScanBlockTask() {
+	if !bs.isFinalizedHeadsAlive() {
+		bs.scanBlockTask()
+	}
+
+	//扫描交易池
+	if bs.IsScanMemPool {
+		bs.ScanTxMemPool()
+	}
+}
+
+//scanBlockTask 轮询和区块头推送都由这里扫描，节点不可用时暂停扫描，等待节点恢复
+func (bs *CENNZBlockScanner) scanBlockTask() {
+	bs.scanLock.Lock()
+	defer bs.scanLock.Unlock()
+
+	err := bs.wm.ApiClient.checkHealth()
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner paused, node unhealthy: %v", err)
+		return
+	}
 
 	//获取本地区块高度
 	blockHeader, err := bs.GetScannedBlockHeader()
@@ -117,6 +150,11 @@ func (bs *CENNZBlockScanner) ScanBlockTask() {
 	currentHeight := blockHeader.Height
 	currentHash := blockHeader.Hash
 	var previousHeight uint64 = 0
+	var maxHeight uint64 = 0 //扫描的目标高度
+
+	//并发预取后续区块，按高度顺序提交
+	prefetcher := newBlockPrefetcher(bs)
+	defer prefetcher.stop()
 
 	for {
 
@@ -132,37 +170,32 @@ func (bs *CENNZBlockScanner) ScanBlockTask() {
 			break
 		}
 
-		//获取最大高度
-		maxHeight, err := bs.wm.GetBlockHeight()
-		if err != nil {
-			//下一个高度找不到会报异常
-			bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
-			break
-		}
-
-		//是否已到最新高度
+		//扫描到缓存的目标高度时才重新获取扫描的目标高度
 		if currentHeight >= maxHeight {
-			bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
-			break
+			maxHeight, err = bs.wm.GetScanTargetHeight()
+			if err != nil {
+				//下一个高度找不到会报异常
+				bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
+				break
+			}
+
+			//是否已到最新高度
+			if currentHeight >= maxHeight {
+				bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
+				break
+			}
 		}
 
 		//继续扫描下一个区块
 		currentHeight = currentHeight + 1
 		bs.wm.Log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		localBlock, err := bs.wm.ApiClient.getBlockByHeight(currentHeight)
+		localBlock, err := prefetcher.get(currentHeight, maxHeight)
 		if err != nil {
 			bs.wm.Log.Std.Info("getBlockByHeight failed; unexpected error: %v", err)
 			break
 		}
 
-		if localBlock.Finalized==false {
-			bs.wm.Log.Std.Info("localBlock is not finalized, height : %d ", localBlock.Height)
-			break
-		}
-
-		isFork := false
-
 		//判断hash是否上一区块的hash
 		if currentHash != localBlock.PrevBlockHash {
 			previousHeight = currentHeight - 1
@@ -170,68 +203,44 @@ func (bs *CENNZBlockScanner) ScanBlockTask() {
 			bs.wm.Log.Std.Info("block height: %d local hash = %s ", previousHeight, currentHash)
 			bs.wm.Log.Std.Info("block height: %d mainnet hash = %s ", previousHeight, localBlock.PrevBlockHash)
 
-			bs.wm.Log.Std.Info("delete recharge records on block height: %d.", previousHeight)
-
-			//删除上一区块链的所有充值记录
-			//bs.DeleteRechargesByHeight(currentHeight - 1)
-			forkBlock, _ := bs.GetLocalBlock(previousHeight)
-			//删除上一区块链的未扫记录
-			bs.wm.Blockscanner.DeleteUnscanRecord(previousHeight)
-			currentHeight = previousHeight - 1 //倒退2个区块重新扫描
-			if currentHeight <= 0 {
-				currentHeight = 1
-			}
-
-			localBlock, err = bs.GetLocalBlock(currentHeight)
-			if err != nil && err != storm.ErrNotFound {
-				bs.wm.Log.Std.Error("block scanner can not get local block; unexpected error: %v", err)
+			//向前查找与链上一致的共同祖先
+			ancestor, orphaned, err := bs.findCommonAncestor(previousHeight)
+			if err != nil {
+				bs.wm.Log.Std.Error("block scanner can not find common ancestor; unexpected error: %v", err)
 				break
-			} else if err == storm.ErrNotFound {
-				//查找core钱包的RPC
-				bs.wm.Log.Info("block scanner prev block height:", currentHeight)
-
-				localBlock, err = bs.wm.ApiClient.getBlockByHeight(currentHeight)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+			}
 
+			//撤回所有孤立区块及其提取的交易
+			for _, forkBlock := range orphaned {
+				bs.rollbackBlock(forkBlock)
 			}
 
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+			//从共同祖先开始重新扫描
+			currentHeight = ancestor.Height
+			currentHash = ancestor.Hash
 
-			bs.wm.Log.Std.Info("rescan block on height: %d, hash: %s .", currentHeight, currentHash)
+			bs.wm.Log.Std.Info("rescan block from common ancestor height: %d, hash: %s, %d blocks orphaned.", currentHeight, currentHash, len(orphaned))
 
 			//重新记录一个新扫描起点
-			bs.wm.Blockscanner.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
-
-			isFork = true
-
-			if forkBlock != nil {
-				//通知分叉区块给观测者，异步处理
-				bs.newBlockNotify(forkBlock, isFork)
-			}
-
-		} else {
+			bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
 
-			err = bs.BatchExtractTransaction(localBlock.Height, localBlock.Hash, localBlock.Transactions, false)
-			if err != nil {
-				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-			}
+			continue
+		}
 
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+		err = bs.BatchExtractTransaction(localBlock.Height, localBlock.Hash, localBlock.Transactions, false)
+		if err != nil {
+			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+		}
 
-			//保存本地新高度
-			bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
-			bs.SaveLocalBlock(localBlock)
+		//重置当前区块的hash
+		currentHash = localBlock.Hash
 
-			isFork = false
-		}
+		//保存本地新高度
+		bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
+		bs.SaveLocalBlock(localBlock)
 
 		//通知新区块给观测者，异步处理
-		bs.newBlockNotify(localBlock, isFork)
+		bs.newBlockNotify(localBlock, false)
 	}
 
 	//重扫前N个块，为保证记录找到
@@ -291,12 +300,23 @@ func (bs *CENNZBlockScanner) ScanTxMemPool() {
 		return
 	}
 
-	if len(txIDsInMemPool) == 0 {
+	//已通知过的交易不再重复通知，离开交易池的交易从记录中移除
+	notified := make(map[string]bool, len(txIDsInMemPool))
+	newTxs := make([]Transaction, 0)
+	for _, tx := range txIDsInMemPool {
+		notified[tx.TxID] = true
+		if !bs.memPoolNotified[tx.TxID] {
+			newTxs = append(newTxs, tx)
+		}
+	}
+	bs.memPoolNotified = notified
+
+	if len(newTxs) == 0 {
 		bs.wm.Log.Std.Info("no transactions in mempool ...")
 		return
 	}
 
-	err = bs.BatchExtractTransaction(0, "", txIDsInMemPool, true)
+	err = bs.BatchExtractTransaction(0, "", newTxs, true)
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 	}
@@ -378,6 +398,7 @@ func (bs *CENNZBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHa
 		done       = 0 //完成标记
 		failed     = 0
 		shouldDone = len(txs) //需要完成的总数
+		extracted  = &ExtractedBlock{Hash: blockHash, Height: blockHeight} //已通知的提取结果，分叉时撤回
 	)
 
 	if len(txs) == 0 {
@@ -399,6 +420,12 @@ func (bs *CENNZBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHa
 
 			if gets.Success {
 
+				for _, extractData := range gets.extractData {
+					for key, data := range extractData {
+						extracted.Data = append(extracted.Data, ExtractedData{SourceKey: key, Data: data})
+					}
+				}
+
 				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
 				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
 				if notifyErr != nil {
@@ -448,6 +475,14 @@ func (bs *CENNZBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHa
 	//以下使用生产消费模式
 	bs.extractRuntime(producer, worker, quit)
 
+	//交易池的交易没有区块，不需要记录
+	if !memPool {
+		err := bs.saveExtractedBlock(extracted)
+		if err != nil {
+			bs.wm.Log.Std.Error("block height: %d, save extracted data failed. unexpected error: %v", blockHeight, err)
+		}
+	}
+
 	if failed > 0 {
 		return fmt.Errorf("block scanner saveWork failed")
 	} else {
@@ -545,6 +580,19 @@ func (bs *CENNZBlockScanner) extractTransaction(trx *Transaction, result *Extrac
 			//手续费
 			fees := totalSpent.Sub(totalReceived)
 
+			status := openwallet.TxStatusSuccess
+			reason := ""
+			//交易池中的交易只通知入账
+			if trx.Status == TxStatusPending {
+				status = TxStatusPending
+				tokenExtractInput = nil
+			}
+			//链上执行失败的交易，只通知扣除的手续费
+			if trx.Status == openwallet.TxStatusFail {
+				status = openwallet.TxStatusFail
+				reason = trx.Reason
+			}
+
 			for token, sourceExtractInput := range tokenExtractInput {
 
 				tokenFees := decimal.Zero
@@ -586,7 +634,8 @@ func (bs *CENNZBlockScanner) extractTransaction(trx *Transaction, result *Extrac
 							TxID:        trx.TxID,
 							Decimal:     decimals,
 							ConfirmTime: blocktime,
-							Status:      openwallet.TxStatusSuccess,
+							Status:      status,
+							Reason:      reason,
 							TxType:      0,
 						}
 						wxID := openwallet.GenTransactionWxID(extractData.Transaction)
@@ -639,7 +688,8 @@ func (bs *CENNZBlockScanner) extractTransaction(trx *Transaction, result *Extrac
 							TxID:        trx.TxID,
 							Decimal:     decimals,
 							ConfirmTime: blocktime,
-							Status:      openwallet.TxStatusSuccess,
+							Status:      status,
+							Reason:      reason,
 							TxType:      0,
 						}
 						wxID := openwallet.GenTransactionWxID(extractData.Transaction)
@@ -966,9 +1016,9 @@ func (bs *CENNZBlockScanner) GetScannedBlockHeader() (*openwallet.BlockHeader, e
 		return nil, err
 	}
 
-	//如果本地没有记录，查询接口的高度
+	//如果本地没有记录，查询接口的扫描目标高度
 	if blockHeight == 0 {
-		blockHeight, err = bs.wm.GetBlockHeight()
+		blockHeight, err = bs.wm.GetScanTargetHeight()
 		if err != nil {
 			bs.wm.Log.Errorf("XRP GetBlockHeight failed,err = %v", err)
 			return nil, err
@@ -1100,6 +1150,26 @@ func (wm *WalletManager) GetBlockHeight() (uint64, error) {
 	return wm.ApiClient.getBlockHeight()
 }
 
+//GetScanTargetHeight 扫描的目标高度，finalized 为已确认的最新区块，confirmations 为最新区块减去确认数
+func (wm *WalletManager) GetScanTargetHeight() (uint64, error) {
+	if wm.Config.ScanTarget == ScanTargetConfirmations {
+		height, err := wm.ApiClient.getBlockHeight()
+		if err != nil {
+			return 0, err
+		}
+		if height <= wm.Config.Confirmations {
+			return 0, nil
+		}
+		return height - wm.Config.Confirmations, nil
+	}
+
+	block, err := wm.ApiClient.getFinalizedBlock()
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
 //GetLocalNewBlock 获取本地记录的区块高度和hash
 func (bs *CENNZBlockScanner) GetLocalNewBlock() (uint64, string, error) {
 
@@ -1136,11 +1206,49 @@ func (bs *CENNZBlockScanner) SaveLocalNewBlock(blockHeight uint64, blockHash str
 
 //GetTxIDsInMemPool 获取待处理的交易池中的交易单IDs
 func (wm *WalletManager) GetTxIDsInMemPool() ([]Transaction, error) {
-	return nil, nil
+	extrinsics, err := wm.ApiClient.getPendingExtrinsics()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extrinsics) == 0 {
+		return nil, nil
+	}
+
+	indexes, err := wm.ApiClient.getRuntimeIndexes("")
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0)
+	for _, extrinsic := range extrinsics {
+		transaction, err := NewPendingTransaction(extrinsic, indexes, wm.Decoder)
+		if err != nil {
+			wm.Log.Debug("skip pending extrinsic : ", err)
+			continue
+		}
+		if transaction == nil {
+			continue
+		}
+		transactions = append(transactions, *transaction)
+	}
+
+	return transactions, nil
 }
 
 func (wm *WalletManager) GetTransactionInMemPool(txid string) (*Transaction, error) {
-	return nil, nil
+	transactions, err := wm.GetTxIDsInMemPool()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range transactions {
+		if transactions[i].TxID == txid {
+			return &transactions[i], nil
+		}
+	}
+
+	return nil, errors.New("transaction not found in mempool: " + txid)
 }
 
 //GetAssetsAccountBalanceByAddress 查询账户相关地址的交易记录
@@ -1156,15 +1264,15 @@ func (bs *CENNZBlockScanner) GetBalanceByAddress(address ...string) ([]*openwall
 			return nil, err
 		}
 
-		balanceUint := uint64(0)
+		balanceBigInt := big.NewInt(0)
 		if balance!=nil && balance.Balance!=nil {
-			balanceUint = uint64(balance.Balance.Int64())
+			balanceBigInt = balance.Balance
 		}
 
 		addrsBalance = append(addrsBalance, &openwallet.Balance{
 			Symbol:  bs.wm.Symbol(),
 			Address: addr,
-			Balance: convertToAmount(balanceUint, 4),
+			Balance: common.BigIntToDecimals(balanceBigInt, 4).String(),
 		})
 	}
 
@@ -1176,12 +1284,16 @@ func (bs *CENNZBlockScanner) Run() error {
 
 	bs.BlockScannerBase.Run()
 
+	bs.startFinalizedHeads()
+
 	return nil
 }
 
 ////Stop 停止扫描
 func (bs *CENNZBlockScanner) Stop() error {
 
+	bs.stopFinalizedHeads()
+
 	bs.BlockScannerBase.Stop()
 
 	return nil
@@ -1190,6 +1302,8 @@ func (bs *CENNZBlockScanner) Stop() error {
 //Pause 暂停扫描
 func (bs *CENNZBlockScanner) Pause() error {
 
+	bs.stopFinalizedHeads()
+
 	bs.BlockScannerBase.Pause()
 
 	return nil
@@ -1200,14 +1314,97 @@ func (bs *CENNZBlockScanner) Restart() error {
 
 	bs.BlockScannerBase.Restart()
 
+	bs.startFinalizedHeads()
+
 	return nil
 }
 
-/******************* 使用insight socket.io 监听区块 *******************/
+/******************* 使用 websocket 订阅已确认区块头 *******************/
 
-//setupSocketIO 配置socketIO监听新区块
-func (bs *CENNZBlockScanner) setupSocketIO() error {
-	return nil
+//startFinalizedHeads 使用 websocket 连接时，订阅已确认区块头，收到后立即扫描
+func (bs *CENNZBlockScanner) startFinalizedHeads() {
+	if bs.wm.ApiClient == nil || !bs.wm.ApiClient.supportSubscribe() {
+		return
+	}
+
+	bs.headsLock.Lock()
+	defer bs.headsLock.Unlock()
+
+	if bs.headsQuit != nil {
+		return
+	}
+	bs.headsQuit = make(chan struct{})
+
+	go bs.subscribeFinalizedHeads(bs.headsQuit)
+}
+
+func (bs *CENNZBlockScanner) stopFinalizedHeads() {
+	bs.headsLock.Lock()
+	defer bs.headsLock.Unlock()
+
+	if bs.headsQuit != nil {
+		close(bs.headsQuit)
+		bs.headsQuit = nil
+	}
+	bs.lastFinalizedHead = time.Time{}
+}
+
+//isFinalizedHeadsAlive 订阅是否在正常推送
+func (bs *CENNZBlockScanner) isFinalizedHeadsAlive() bool {
+	bs.headsLock.Lock()
+	defer bs.headsLock.Unlock()
+
+	return bs.headsQuit != nil && time.Since(bs.lastFinalizedHead) < finalizedHeadsTimeout
+}
+
+func (bs *CENNZBlockScanner) subscribeFinalizedHeads(quit chan struct{}) {
+	for {
+		sub, err := bs.wm.ApiClient.subscribe("chain_subscribeFinalizedHeads", "chain_unsubscribeFinalizedHeads", nil)
+		if err != nil {
+			bs.wm.Log.Std.Error("subscribe finalized heads failed, fall back to polling; unexpected error: %v", err)
+		} else {
+			bs.wm.Log.Std.Info("block scanner subscribed finalized heads")
+
+			bs.receiveFinalizedHeads(sub, quit)
+			sub.Unsubscribe()
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(finalizedHeadsRetryWait):
+		}
+	}
+}
+
+//receiveFinalizedHeads 每收到一个已确认区块头扫描一次，订阅关闭时返回
+func (bs *CENNZBlockScanner) receiveFinalizedHeads(sub *Subscription, quit chan struct{}) {
+	for {
+		select {
+		case <-quit:
+			return
+		case header, ok := <-sub.C:
+			if !ok {
+				bs.wm.Log.Std.Info("finalized heads subscription closed, fall back to polling")
+				return
+			}
+
+			height, err := parseBlockNumber(header)
+			if err != nil {
+				continue
+			}
+
+			bs.headsLock.Lock()
+			bs.lastFinalizedHead = time.Now()
+			bs.headsLock.Unlock()
+
+			bs.wm.Log.Std.Info("block scanner received finalized head: %d", height)
+
+			if bs.Scanning {
+				bs.scanBlockTask()
+			}
+		}
+	}
 }
 
 //SupportBlockchainDAI 支持外部设置区块链数据访问接口
@@ -18,10 +18,13 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/astaxie/beego/config"
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
 )
 
 //初始化配置流程
@@ -155,17 +158,93 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.RpcAPI = c.String("rpcAPI")
 	wm.Config.WSAPI = c.String("wsAPI")
 	wm.Config.APIChoose = c.String("apiChoose")
-	NewApiClient(wm)
+	wm.Config.Quorum, _ = c.Int("quorum")
+	if minPeers, err := c.Int64("minPeers"); err == nil && minPeers >= 0 {
+		wm.Config.MinPeers = uint64(minPeers)
+	}
+	if maxBlockLag, err := c.Int64("maxBlockLag"); err == nil && maxBlockLag >= 0 {
+		wm.Config.MaxBlockLag = uint64(maxBlockLag)
+	}
+	err := NewApiClient(wm)
+	if err != nil {
+		return err
+	}
 
 	wm.Config.FixedFee, _ = c.Int64("fixedFee")
+	if feeMargin := c.String("feeMargin"); len(feeMargin) > 0 {
+		margin, err := decimal.NewFromString(feeMargin)
+		if err != nil || margin.LessThan(decimal.Zero) {
+			return fmt.Errorf("invalid feeMargin: %s", feeMargin)
+		}
+		wm.Config.FeeMargin = margin
+	}
 	wm.Config.ReserveAmount, _ = c.Int64("reserveAmount")
 	wm.Config.IgnoreReserve, _ = c.Bool("ignoreReserve")
+	if eraPeriod, err := c.Int64("eraPeriod"); err == nil && eraPeriod >= 0 {
+		wm.Config.EraPeriod = uint64(eraPeriod)
+	}
+
+	//sr25519 私钥由 HD 派生私钥加上偏移量得到，地址由 sr25519 公钥生成
+	switch strings.ToLower(c.String("signatureType")) {
+	case "", SignatureEd25519:
+		wm.Config.SignatureType = cennzTransaction.SignatureTypeEd25519
+	case SignatureSr25519:
+		wm.Config.SignatureType = cennzTransaction.SignatureTypeSr25519
+	default:
+		return fmt.Errorf("unsupported signatureType: %s", c.String("signatureType"))
+	}
+
+	switch batchCall := c.String("batchCall"); batchCall {
+	case "":
+	case BatchCallBatch, BatchCallBatchAll:
+		wm.Config.BatchCall = batchCall
+	default:
+		return fmt.Errorf("unsupported batchCall: %s", batchCall)
+	}
+
+	//CPAY 不足时通过 CENNZX 使用其他资产支付手续费
+	if assetId, err := c.Int64("feeExchangeAssetId"); err == nil && assetId > 0 {
+		maxPayment, err := parseBigIntAmount(c.String("feeExchangeMaxPayment"))
+		if err != nil || maxPayment.Sign() <= 0 {
+			return fmt.Errorf("invalid feeExchangeMaxPayment: %s", c.String("feeExchangeMaxPayment"))
+		}
+		wm.Config.FeeExchangeAssetId = uint64(assetId)
+		wm.Config.FeeExchangeMaxPayment = maxPayment
+	}
+
+	//通过 websocket 订阅提交的交易单状态
+	wm.Config.SubmitAndWatch, _ = c.Bool("submitAndWatch")
+	if wm.Config.SubmitAndWatch && wm.Config.APIChoose != APIClientWSMode {
+		return fmt.Errorf("submitAndWatch requires apiChoose = %s", APIClientWSMode)
+	}
+
+	switch scanTarget := c.String("scanTarget"); scanTarget {
+	case "":
+	case ScanTargetFinalized, ScanTargetConfirmations:
+		wm.Config.ScanTarget = scanTarget
+	default:
+		return fmt.Errorf("unsupported scanTarget: %s", scanTarget)
+	}
+	if confirmations, err := c.Int64("confirmations"); err == nil && confirmations >= 0 {
+		wm.Config.Confirmations = uint64(confirmations)
+	}
+
+	//扫描交易池，提前通知未打包的入账
+	wm.Blockscanner.IsScanMemPool, _ = c.Bool("scanMemPool")
 
 	wm.Config.DataDir = c.String("dataDir")
 
 	//数据文件夹
 	wm.Config.makeDataDir()
 
+	//继续跟踪重启前未进入最终状态的交易单
+	if wm.Config.SubmitAndWatch {
+		err = wm.resumeSubmittedTransactions()
+		if err != nil {
+			wm.Log.Error("resume submitted transactions failed, error : ", err)
+		}
+	}
+
 	return nil
 }
 
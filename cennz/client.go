@@ -2,20 +2,43 @@ package cennz
 
 import (
 	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
 )
 
 const APIClientHttpMode = "http"
 const APIClientAllRpcMode = "allRpc"
+const APIClientWSMode = "ws"
 
 type ApiClient struct {
-	Client    *Client
-	RpcClient *RpcClient
-	BalanceApiClient *BalanceApiClient
+	//使用的 backend 名称，内置 http、allRpc、ws，也可以是 RegisterBackend 注册的名称
 	APIChoose string
+	//区块hash和余额需要一致的节点数量，小于等于1时不检查
+	Quorum int
+
+	//所有节点，请求按轮询分配，失败时切换到下一个节点
+	nodes    []*apiNode
+	nextNode uint32
+
+	//按 specVersion 缓存的元数据序号
+	runtimeIndexes *runtimeIndexesCache
+
+	//节点至少连接的 peer 数量和允许落后网络的区块数
+	MinPeers    uint64
+	MaxBlockLag uint64
+
+	//最近一次节点状态检查的结果
+	healthLock      sync.Mutex
+	healthCheckedAt time.Time
+	healthErr       error
 }
 
 func NewApiClient(wm *WalletManager) error {
-	api := ApiClient{}
+	api := ApiClient{
+		runtimeIndexes: newRuntimeIndexesCache(),
+	}
 
 	if len(wm.Config.APIChoose) == 0 {
 		wm.Config.APIChoose = APIClientHttpMode //默认采用rpc连接
@@ -24,14 +47,28 @@ func NewApiClient(wm *WalletManager) error {
 		wm.Config.APIChoose = APIClientAllRpcMode //默认采用rpc连接
 	}
 	api.APIChoose = wm.Config.APIChoose
-	if api.APIChoose == APIClientHttpMode {
-		api.Client = NewClient(wm.Config.NodeAPI, false, wm.Symbol() )
-		api.BalanceApiClient = NewBalanceClient(wm.Config.BalanceAPI, false, wm.Symbol())
-		api.RpcClient = NewRpcClient(wm.Config.RpcAPI, false, wm.Symbol() )
+	api.Quorum = wm.Config.Quorum
+	api.MinPeers = wm.Config.MinPeers
+	api.MaxBlockLag = wm.Config.MaxBlockLag
+
+	creator, ok := getBackendCreator(api.APIChoose)
+	if !ok {
+		return fmt.Errorf("unsupported apiChoose: %s", api.APIChoose)
+	}
+
+	backends, err := creator(wm.Config)
+	if err != nil {
+		return err
+	}
+	if len(backends) == 0 {
+		return errNoNode
 	}
-	if api.APIChoose == APIClientAllRpcMode {
-		api.BalanceApiClient = NewBalanceClient(wm.Config.BalanceAPI, false, wm.Symbol())
-		api.RpcClient = NewRpcClient(wm.Config.RpcAPI, false, wm.Symbol() )
+	if api.Quorum > len(backends) {
+		return fmt.Errorf("quorum %d is greater than the number of nodes %d", api.Quorum, len(backends))
+	}
+
+	for _, backend := range backends {
+		api.nodes = append(api.nodes, &apiNode{backend: backend})
 	}
 
 	wm.ApiClient = &api
@@ -43,136 +80,231 @@ func NewApiClient(wm *WalletManager) error {
 func (c *ApiClient) getBlockHeight() (uint64, error) {
 	var (
 		currentHeight uint64
-		err           error
 	)
-	if c.APIChoose == APIClientHttpMode {
-		currentHeight, err = c.Client.getBlockHeight()
-	}else if c.APIChoose == APIClientAllRpcMode {
-		currentHeight, err = c.RpcClient.getBlockHeight()
-	}
+	err := c.do(func(node *apiNode) error {
+		var err error
+		currentHeight, err = node.backend.GetBlockHeight()
+		return err
+	})
 
 	return currentHeight, err
 }
 
 // 获取地址余额
 func (c *ApiClient) getBalance(address string, assetId string) (*AddrBalance, error) {
-	var (
-		balance *AddrBalance
-		err     error
-	)
+	//所有节点查询同一个已确认区块的余额
+	finalizedHeadBlockHash, err := c.getFinalizedHead()
+	if err != nil {
+		return nil, err
+	}
 
-	if c.APIChoose == APIClientHttpMode {
-		balance, err = c.Client.getBalance(address, assetId)
+	result, err := c.quorum(func(node *apiNode) (string, interface{}, error) {
+		balance, err := node.backend.GetBalance(address, assetId, finalizedHeadBlockHash)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
 
-		finalizedHeadBlockHash, err := c.RpcClient.GetFinalizedHead()
-		if err != nil {
-			return nil, err
-		}
+		key := fmt.Sprintf("%v:%v:%v:%d", balance.Balance, balance.Free, balance.Freeze, balance.Nonce)
+		return key, balance, nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		balance, err = c.BalanceApiClient.getApiBalance(balance, finalizedHeadBlockHash)
-	}else if c.APIChoose == APIClientAllRpcMode {
-		finalizedHeadBlockHash, err := c.RpcClient.GetFinalizedHead()
-		if err != nil {
-			return nil, err
-		}
+	return result.(*AddrBalance), nil
+}
+
+// 获取地址nonce
+func (c *ApiClient) getNonce(address string) (uint64, error) {
+	finalizedHeadBlockHash, err := c.getFinalizedHead()
+	if err != nil {
+		return 0, err
+	}
 
-		balance, err = c.BalanceApiClient.getApiBalanceWithNonce(address, finalizedHeadBlockHash, assetId)
+	result, err := c.quorum(func(node *apiNode) (string, interface{}, error) {
+		nonce, err := node.backend.GetNonce(address, finalizedHeadBlockHash)
+		return fmt.Sprint(nonce), nonce, err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return balance, err
+	return result.(uint64), nil
 }
 
 func (c *ApiClient) getBlockByHeight(height uint64) (*Block, error) {
 	var (
 		block *Block
+		hash  string
 		err   error
 	)
-	if c.APIChoose == APIClientHttpMode {
-		block, err = c.Client.getBlockByHeight(height)
-		if err!=nil {
-			return nil, err
-		}
 
-		hashInRpc, err := c.RpcClient.GetBlockHash(height)
+	//开启 quorum 时区块hash需要多个节点一致
+	if c.Quorum > 1 {
+		hash, err = c.getBlockHash(height)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if hashInRpc!=block.Hash {
-			return nil, errors.New("wrong block, rpc :" + hashInRpc + ", http : " + block.Hash )
+	err = c.do(func(node *apiNode) error {
+		var err error
+		block, err = node.backend.GetBlockByHeight(height)
+		if err != nil {
+			return err
 		}
-	}else if c.APIChoose == APIClientAllRpcMode {
-		block, err = c.BalanceApiClient.getBlockByHeight(height)
-		if err!=nil {
-			return nil, err
+
+		if len(hash) > 0 && hash != block.Hash {
+			return errors.New("wrong block, rpc :" + hash + ", node : " + block.Hash )
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return block, err
+	return block, nil
 }
 
 func (c *ApiClient) sendTransaction(rawTx string) (string, error) {
 	var (
 		txid string
-		err  error
 	)
-	if c.APIChoose == APIClientHttpMode || c.APIChoose == APIClientAllRpcMode {
-		txid, err = c.RpcClient.sendTransaction(rawTx)
-	}
+	err := c.do(func(node *apiNode) error {
+		var err error
+		txid, err = node.backend.SendTransaction(rawTx)
+		return err
+	})
 
 	return txid, err
 }
 
-func (c *ApiClient) getMetadata() (*Metadata, error) {
+//广播交易并订阅状态变化
+func (c *ApiClient) submitAndWatchTransaction(rawTx string) (*Subscription, error) {
+	return c.subscribe("author_submitAndWatchExtrinsic", "author_unwatchExtrinsic", []interface{}{rawTx})
+}
+
+//是否有节点支持订阅
+func (c *ApiClient) supportSubscribe() bool {
+	for _, node := range c.nodes {
+		if backend, ok := node.backend.(SubscribeBackend); ok && backend.SupportSubscribe() {
+			return true
+		}
+	}
+	return false
+}
+
+//订阅节点推送，只使用支持订阅的节点
+func (c *ApiClient) subscribe(method, unsubscribeMethod string, params []interface{}) (*Subscription, error) {
+	err := errors.New("subscription is not supported by " + c.APIChoose)
+	for _, node := range c.pickNodes() {
+		backend, ok := node.backend.(SubscribeBackend)
+		if !ok || !backend.SupportSubscribe() {
+			continue
+		}
+
+		var sub *Subscription
+		sub, err = backend.Subscribe(method, unsubscribeMethod, params)
+		if err == nil || isRpcError(err) {
+			node.succeed()
+			return sub, err
+		}
+		node.fail(err)
+	}
+
+	return nil, err
+}
+
+//查询交易的手续费，返回 partialFee
+func (c *ApiClient) queryFeeInfo(extrinsic string) (*big.Int, error) {
 	var (
-		metadata    *Metadata
-		err         error
+		fee *big.Int
 	)
-	if c.APIChoose == APIClientHttpMode {
-		metadata, err = c.Client.getMetaData()
-	}
+	err := c.do(func(node *apiNode) error {
+		var err error
+		fee, err = node.backend.QueryFeeInfo(extrinsic)
+		return err
+	})
+
+	return fee, err
+}
 
-	return metadata, err
+//获取交易池中等待打包的交易单
+func (c *ApiClient) getPendingExtrinsics() ([]string, error) {
+	var (
+		extrinsics []string
+	)
+	err := c.do(func(node *apiNode) error {
+		var err error
+		extrinsics, err = node.backend.GetPendingExtrinsics()
+		return err
+	})
+
+	return extrinsics, err
 }
 
 func (c *ApiClient) getRuntimeVersion() (*RuntimeVersion, error){
 	var (
 		result    *RuntimeVersion
-		err         error
 	)
-	if c.APIChoose == APIClientHttpMode || c.APIChoose == APIClientAllRpcMode {
-		result, err = c.RpcClient.GetRuntimeVersion()
-	}
+	err := c.do(func(node *apiNode) error {
+		var err error
+		result, err = node.backend.GetRuntimeVersion("")
+		return err
+	})
 
 	return result, err
 }
 
-//获取当前最新高度
-func (c *ApiClient) getMostHeightBlock() (*Block, error) {
+func (c *ApiClient) getGenesisBlockHash() (string, error) {
+	result, err := c.quorum(func(node *apiNode) (string, interface{}, error) {
+		hash, err := node.backend.GetGenesisHash()
+		return hash, hash, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return result.(string), nil
+}
+
+//获取已确认的最新区块，交易的era以此为起点
+func (c *ApiClient) getFinalizedBlock() (*Block, error) {
 	var (
-		mostHeightBlock *Block
-		err             error
+		finalizedBlock *Block
 	)
-	if c.APIChoose == APIClientHttpMode {
-		mostHeightBlock, err = c.Client.getMostHeightBlock()
-	}else if c.APIChoose == APIClientAllRpcMode {
-		mostHeightBlock, err = c.RpcClient.getMostHeightBlock()
-	}
+	err := c.do(func(node *apiNode) error {
+		var err error
+		finalizedBlock, err = node.backend.GetFinalizedBlock()
+		return err
+	})
 
-	return mostHeightBlock, err
+	return finalizedBlock, err
 }
 
-func (c *ApiClient) getGenesisBlockHash() (string, error) {
+//获取已确认的最新区块hash
+func (c *ApiClient) getFinalizedHead() (string, error) {
 	var (
 		result string
-		err    error
 	)
-	if c.APIChoose == APIClientHttpMode || c.APIChoose == APIClientAllRpcMode {
-		result, err = c.RpcClient.GetGenesisHash()
-	}
+	err := c.do(func(node *apiNode) error {
+		var err error
+		result, err = node.backend.GetFinalizedHead()
+		return err
+	})
 
 	return result, err
 }
+
+//获取指定高度的区块hash，开启 quorum 时需要多个节点一致
+func (c *ApiClient) getBlockHash(height uint64) (string, error) {
+	result, err := c.quorum(func(node *apiNode) (string, interface{}, error) {
+		hash, err := node.backend.GetBlockHash(height)
+		return hash, hash, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return result.(string), nil
+}
@@ -17,10 +17,12 @@ package cennz
 
 import (
 	"fmt"
+	"math/big"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	owcrypt "github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/common/file"
 	"github.com/shopspring/decimal"
@@ -34,6 +36,24 @@ const (
 	AddrPrefix = 0x2a
 )
 
+//签名类型
+const (
+	SignatureEd25519 = "ed25519"
+	SignatureSr25519 = "sr25519"
+)
+
+//批量转账的调用，batch 中失败的转账不影响之前的转账，batch_all 全部成功或全部失败
+const (
+	BatchCallBatch    = "batch"
+	BatchCallBatchAll = "batch_all"
+)
+
+//扫描的目标高度，finalized 扫描到已确认的最新区块，confirmations 扫描到最新区块减去确认数
+const (
+	ScanTargetFinalized     = "finalized"
+	ScanTargetConfirmations = "confirmations"
+)
+
 type WalletConfig struct {
 
 	//币种
@@ -61,6 +81,12 @@ type WalletConfig struct {
 	WSAPI string
 	// rpc - NodeAPI   ws - WSAPI
 	APIChoose string
+	// number of nodes that must agree on block hashes and balances, 0 or 1 disables the check
+	Quorum int
+	// minimum peers the node must be connected to
+	MinPeers uint64
+	// maximum blocks the node may lag behind the network
+	MaxBlockLag uint64
 	//钱包安装的路径
 	NodeInstallPath string
 	//钱包数据文件目录
@@ -75,14 +101,32 @@ type WalletConfig struct {
 	DefaultConfig string
 	//曲线类型
 	CurveType uint32
-	//fixed fee in sawi
+	//fixed fee in sawi, used when payment_queryInfo is unavailable
 	FixedFee int64
+	// safety margin added to the queried fee, 0.2 = 20%
+	FeeMargin decimal.Decimal
 	// reserve amount in smallest unit
 	ReserveAmount int64
 	// ignore reserve amount or not
 	IgnoreReserve bool
 	// data directory
 	DataDir string
+	// mortal era period in blocks, 0 means immortal
+	EraPeriod uint64
+	// extrinsic signature type, ed25519 or sr25519
+	SignatureType byte
+	// utility call for multi-recipient payouts, batch or batch_all
+	BatchCall string
+	// asset used to pay fees through CENNZX when the CPAY balance is not enough, 0 means disabled
+	FeeExchangeAssetId uint64
+	// max amount of the fee exchange asset paid for fees, in smallest unit
+	FeeExchangeMaxPayment *big.Int
+	// submit with author_submitAndWatchExtrinsic and record the status changes, ws mode only
+	SubmitAndWatch bool
+	// scan target, finalized or confirmations
+	ScanTarget string
+	// confirmations below the best head used when ScanTarget = confirmations
+	Confirmations uint64
 
 	AddrPrefix byte
 	Decimal int32
@@ -120,6 +164,17 @@ func NewConfig(symbol string, masterKey string, AddrPrefix byte) *WalletConfig {
 	c.SumAddress = ""
 	//汇总执行间隔时间
 	c.CycleSeconds = time.Second * 10
+	//交易有效期
+	c.EraPeriod = cennzTransaction.Default_Period
+	//批量转账调用
+	c.BatchCall = BatchCallBatchAll
+	//手续费安全余量
+	c.FeeMargin = decimal.NewFromFloat(0.2)
+	c.MinPeers = 1
+	c.MaxBlockLag = 10
+	//扫描到已确认的最新区块
+	c.ScanTarget = ScanTargetFinalized
+	c.Confirmations = 100
 
 	//默认配置内容
 	c.DefaultConfig = `
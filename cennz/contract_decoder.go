@@ -49,13 +49,13 @@ func convertBigIntToFloatDecimal(amount string) (decimal.Decimal, error) {
 }
 
 func convertIntStringToBigInt(amount string) (*big.Int, error) {
-	vInt64, err := strconv.ParseInt(amount, 10, 64)
+	v, err := parseBigIntAmount(amount)
 	if err != nil {
 		log.Error("convert from string to int failed, err=", err)
 		return nil, err
 	}
 
-	return big.NewInt(vInt64), nil
+	return v, nil
 }
 
 type ContractDecoder struct {
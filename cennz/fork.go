@@ -0,0 +1,156 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"path/filepath"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	//区块提取记录文件，分叉时用于撤回已通知的交易
+	extractedFile = "extracted.db"
+	//向前查找共同祖先的最大区块数，同时也是提取记录保留的区块数
+	maxForkDepth = 1000
+)
+
+// ExtractedBlock 一个区块中已通知给观测者的提取结果
+type ExtractedBlock struct {
+	Hash   string          `json:"hash" storm:"id"`
+	Height uint64          `json:"height" storm:"index"`
+	Data   []ExtractedData `json:"data"`
+}
+
+// ExtractedData 一条已通知的提取结果
+type ExtractedData struct {
+	SourceKey string                    `json:"sourceKey"`
+	Data      *openwallet.TxExtractData `json:"data"`
+}
+
+//saveExtractedBlock 记录区块的提取结果，并删除超过 maxForkDepth 的旧记录
+func (bs *CENNZBlockScanner) saveExtractedBlock(block *ExtractedBlock) error {
+	bs.extractedLock.Lock()
+	defer bs.extractedLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(bs.wm.Config.dbPath, extractedFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if block.Height > maxForkDepth {
+		err = db.Select(q.Lt("Height", block.Height-maxForkDepth)).Delete(&ExtractedBlock{})
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+
+	if len(block.Data) == 0 {
+		return nil
+	}
+
+	return db.Save(block)
+}
+
+//popExtractedBlock 取出并删除区块的提取结果，没有记录时返回 nil
+func (bs *CENNZBlockScanner) popExtractedBlock(hash string) (*ExtractedBlock, error) {
+	bs.extractedLock.Lock()
+	defer bs.extractedLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(bs.wm.Config.dbPath, extractedFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var block ExtractedBlock
+	err = db.One("Hash", hash, &block)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &block, db.DeleteStruct(&block)
+}
+
+//findCommonAncestor 从 height 开始向前对比本地区块和链上的区块hash，返回共同祖先和被孤立的本地区块（从高到低）
+//本地没有记录或超过 maxForkDepth 时，以链上该高度的区块作为祖先
+func (bs *CENNZBlockScanner) findCommonAncestor(height uint64) (*Block, []*Block, error) {
+	orphaned := make([]*Block, 0)
+
+	for ; height > 0; height-- {
+		hash, err := bs.wm.ApiClient.getBlockHash(height)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		localBlock, err := bs.GetLocalBlock(height)
+		if err != nil || len(orphaned) >= maxForkDepth {
+			bs.wm.Log.Std.Warning("block scanner can not verify local block on height: %d, use it as common ancestor", height)
+			return &Block{Height: height, Hash: hash}, orphaned, nil
+		}
+
+		if localBlock.Hash == hash {
+			return localBlock, orphaned, nil
+		}
+
+		orphaned = append(orphaned, localBlock)
+	}
+
+	return nil, nil, openwallet.Errorf(openwallet.ErrUnknownException, "common ancestor not found")
+}
+
+//rollbackBlock 通知观测者区块已被孤立，已通知的交易以 TxStatusOrphaned 状态重新通知
+func (bs *CENNZBlockScanner) rollbackBlock(block *Block) {
+	bs.wm.Log.Std.Info("block scanner rollback block on height: %d, hash: %s", block.Height, block.Hash)
+
+	//删除孤立区块的未扫记录
+	bs.DeleteUnscanRecord(block.Height)
+
+	//分叉区块，观测者删除该高度的提取记录
+	defer bs.newBlockNotify(block, true)
+
+	extracted, err := bs.popExtractedBlock(block.Hash)
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not load extracted data of block: %s; unexpected error: %v", block.Hash, err)
+		return
+	}
+	if extracted == nil {
+		return
+	}
+
+	//先以撤回状态通知已提取的交易，再通知分叉区块
+	for o := range bs.Observers {
+		for _, item := range extracted.Data {
+			if item.Data == nil || item.Data.Transaction == nil {
+				continue
+			}
+
+			item.Data.Transaction.Status = TxStatusOrphaned
+			item.Data.Transaction.Reason = "block " + block.Hash + " is orphaned by chain reorganization"
+
+			bs.wm.Log.Infof("rollback extract data txid: %s", item.Data.Transaction.TxID)
+			err = o.BlockExtractDataNotify(item.SourceKey, item.Data)
+			if err != nil {
+				bs.wm.Log.Error("BlockExtractDataNotify unexpected error:", err)
+			}
+		}
+	}
+}
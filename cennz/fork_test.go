@@ -0,0 +1,270 @@
+package cennz
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testBlockchainDAI 内存中的本地区块记录
+type testBlockchainDAI struct {
+	openwallet.BlockchainDAIBase
+
+	lock    sync.Mutex
+	current *openwallet.BlockHeader
+	blocks  map[uint64]*openwallet.BlockHeader
+	unscan  map[uint64]*openwallet.UnscanRecord
+}
+
+func newTestBlockchainDAI() *testBlockchainDAI {
+	return &testBlockchainDAI{
+		blocks: make(map[uint64]*openwallet.BlockHeader),
+		unscan: make(map[uint64]*openwallet.UnscanRecord),
+	}
+}
+
+func (dai *testBlockchainDAI) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	dai.current = header
+	return nil
+}
+
+func (dai *testBlockchainDAI) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	if dai.current == nil {
+		return nil, errors.New("current block head not found")
+	}
+	return dai.current, nil
+}
+
+func (dai *testBlockchainDAI) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	dai.blocks[header.Height] = header
+	return nil
+}
+
+func (dai *testBlockchainDAI) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	header, ok := dai.blocks[height]
+	if !ok {
+		return nil, errors.New("local block not found")
+	}
+	return header, nil
+}
+
+func (dai *testBlockchainDAI) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	dai.unscan[record.BlockHeight] = record
+	return nil
+}
+
+func (dai *testBlockchainDAI) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	delete(dai.unscan, height)
+	return nil
+}
+
+func (dai *testBlockchainDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	dai.lock.Lock()
+	defer dai.lock.Unlock()
+
+	records := make([]*openwallet.UnscanRecord, 0)
+	for _, record := range dai.unscan {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+//testScanObserver 记录扫描器的通知
+type testScanObserver struct {
+	lock    sync.Mutex
+	data    []*openwallet.TxExtractData
+	headers chan *openwallet.BlockHeader
+}
+
+func newTestScanObserver() *testScanObserver {
+	return &testScanObserver{headers: make(chan *openwallet.BlockHeader, 100)}
+}
+
+func (o *testScanObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	o.headers <- header
+	return nil
+}
+
+func (o *testScanObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.data = append(o.data, data)
+	return nil
+}
+
+func (o *testScanObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+	return nil
+}
+
+func testBlockHash(height uint64, branch string) string {
+	return fmt.Sprintf("0x%s%062x", branch, height)
+}
+
+//newTestBlockScanner 链上区块为 1 到 chainHeight，本地区块为 1 到 localHeight，forkFrom 及之后的本地区块与链上不同
+func newTestBlockScanner(t *testing.T, chainHeight, localHeight, forkFrom uint64) (*CENNZBlockScanner, *testChainBackend, *testBlockchainDAI, func()) {
+	dir, err := ioutil.TempDir("", "cennz-fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := newTestChainBackend()
+	for height := uint64(1); height <= chainHeight; height++ {
+		backend.setBlock(&Block{Height: height, Hash: testBlockHash(height, "aa"), PrevBlockHash: testBlockHash(height-1, "aa")})
+	}
+
+	dai := newTestBlockchainDAI()
+	for height := uint64(1); height <= localHeight; height++ {
+		branch := "aa"
+		if height >= forkFrom {
+			branch = "bb"
+		}
+		dai.blocks[height] = &openwallet.BlockHeader{Height: height, Hash: testBlockHash(height, branch)}
+	}
+
+	wm := NewWalletManager()
+	wm.Config.dbPath = dir
+	wm.ApiClient = newTestApiClient(backend, nil)
+
+	bs := wm.Blockscanner
+	bs.SetBlockchainDAI(dai)
+
+	return bs, backend, dai, func() {
+		bs.CloseBlockScanner()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFindCommonAncestor(t *testing.T) {
+	bs, _, _, cleanup := newTestBlockScanner(t, 12, 10, 8)
+	defer cleanup()
+
+	ancestor, orphaned, err := bs.findCommonAncestor(10)
+	if err != nil {
+		t.Fatal("find common ancestor failed : ", err)
+	}
+	if ancestor.Height != 7 || ancestor.Hash != testBlockHash(7, "aa") {
+		t.Error("wrong common ancestor : ", ancestor.Height, ancestor.Hash)
+	}
+	if len(orphaned) != 3 || orphaned[0].Height != 10 || orphaned[2].Height != 8 {
+		t.Error("wrong orphaned blocks : ", len(orphaned))
+	}
+}
+
+func TestFindCommonAncestorMissingLocalBlock(t *testing.T) {
+	bs, _, dai, cleanup := newTestBlockScanner(t, 12, 10, 8)
+	defer cleanup()
+
+	//本地没有记录时以链上该高度的区块作为祖先
+	delete(dai.blocks, 7)
+
+	ancestor, orphaned, err := bs.findCommonAncestor(10)
+	if err != nil {
+		t.Fatal("find common ancestor failed : ", err)
+	}
+	if ancestor.Height != 7 || ancestor.Hash != testBlockHash(7, "aa") {
+		t.Error("wrong common ancestor : ", ancestor.Height, ancestor.Hash)
+	}
+	if len(orphaned) != 3 {
+		t.Error("wrong orphaned blocks : ", len(orphaned))
+	}
+}
+
+func TestFindCommonAncestorDepthLimit(t *testing.T) {
+	height := uint64(maxForkDepth + 100)
+	bs, _, _, cleanup := newTestBlockScanner(t, height, height, 1)
+	defer cleanup()
+
+	//超过 maxForkDepth 时停止查找
+	ancestor, orphaned, err := bs.findCommonAncestor(height)
+	if err != nil {
+		t.Fatal("find common ancestor failed : ", err)
+	}
+	if len(orphaned) != maxForkDepth {
+		t.Error("wrong orphaned blocks : ", len(orphaned))
+	}
+	if ancestor.Height != height-maxForkDepth || ancestor.Hash != testBlockHash(height-maxForkDepth, "aa") {
+		t.Error("wrong common ancestor : ", ancestor.Height, ancestor.Hash)
+	}
+}
+
+func TestRollbackBlock(t *testing.T) {
+	bs, _, dai, cleanup := newTestBlockScanner(t, 12, 10, 8)
+	defer cleanup()
+
+	observer := newTestScanObserver()
+	bs.AddObserver(observer)
+
+	orphan := &Block{Height: 9, Hash: testBlockHash(9, "bb")}
+	dai.unscan[orphan.Height] = &openwallet.UnscanRecord{BlockHeight: orphan.Height}
+
+	err := bs.saveExtractedBlock(&ExtractedBlock{
+		Hash:   orphan.Hash,
+		Height: orphan.Height,
+		Data: []ExtractedData{
+			{
+				SourceKey: "account",
+				Data: &openwallet.TxExtractData{
+					Transaction: &openwallet.Transaction{TxID: "0x01", WxID: "wx01", Status: openwallet.TxStatusSuccess},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("save extracted block failed : ", err)
+	}
+
+	bs.rollbackBlock(orphan)
+
+	//已通知的交易以撤回状态重新通知，不使用链上失败的状态
+	if len(observer.data) != 1 {
+		t.Fatal("wrong rollback notifications : ", len(observer.data))
+	}
+	tx := observer.data[0].Transaction
+	if tx.Status != TxStatusOrphaned || tx.WxID != "wx01" || len(tx.Reason) == 0 {
+		t.Error("wrong rollback transaction : ", tx.Status, tx.Reason)
+	}
+
+	select {
+	case header := <-observer.headers:
+		if !header.Fork || header.Height != orphan.Height || header.Hash != orphan.Hash {
+			t.Error("wrong fork header : ", header)
+		}
+	case <-time.After(time.Second):
+		t.Error("fork header is not notified")
+	}
+
+	if _, ok := dai.unscan[orphan.Height]; ok {
+		t.Error("unscan record of orphaned block is not deleted")
+	}
+
+	//提取记录已撤回，不会重复通知
+	extracted, err := bs.popExtractedBlock(orphan.Hash)
+	if err != nil || extracted != nil {
+		t.Error("extracted block is not removed : ", extracted, err)
+	}
+}
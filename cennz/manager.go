@@ -16,14 +16,19 @@
 package cennz
 
 import (
+	"encoding/hex"
 	"errors"
 	"math/big"
 	"path/filepath"
+	"strconv"
+	"sync"
 
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
 )
 
 type WalletManager struct {
@@ -39,6 +44,8 @@ type WalletManager struct {
 	TxDecoder       openwallet.TransactionDecoder //交易单编码器
 	Log             *log.OWLogger                 //日志工具
 	ContractDecoder *ContractDecoder              //智能合约解析器
+
+	submittedLock sync.Mutex //提交的交易单状态记录
 }
 
 func NewWalletManager() *WalletManager {
@@ -147,6 +154,11 @@ func (wm *WalletManager) SendRawTransaction(txHex string) (string, error) {
 }
 
 func (wm *WalletManager) sendRawTransactionByNode(txHex string) (string, error) {
+	//通过 websocket 提交并跟踪交易单状态
+	if wm.Config.SubmitAndWatch {
+		return wm.submitAndWatchTransaction(txHex)
+	}
+
 	txid, err := wm.ApiClient.sendTransaction(txHex)
 
 	if err != nil {
@@ -155,7 +167,7 @@ func (wm *WalletManager) sendRawTransactionByNode(txHex string) (string, error)
 	return txid, nil
 }
 
-func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, value *big.Int, assetId string) (*txFeeInfo, error) {
+func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, value *big.Int, assetId string, feeExchange *cennzTransaction.FeeExchange) (*txFeeInfo, error) {
 
 	var (
 		gasLimit *big.Int
@@ -169,20 +181,131 @@ func (wm *WalletManager) GetTransactionFeeEstimated(from string, to string, valu
 		//		Fee:      fee,
 	}
 
-	feeInfo.Fee = big.NewInt( wm.Config.FixedFee )
+	fee, err := wm.queryTransferFee(from, to, value, assetId, feeExchange)
+	if err != nil {
+		//节点查询失败时使用固定手续费
+		wm.Log.Warning("query transfer fee failed, use fixed fee ", wm.Config.FixedFee, ", error : ", err)
+		feeInfo.Fee = big.NewInt(wm.Config.FixedFee)
+		return feeInfo, nil
+	}
+
+	feeInfo.Fee = fee
+	return feeInfo, nil
+}
+
+//GetTransfersFeeEstimated 按实际的转账列表估算手续费，多笔转账时按批量转账交易查询，feeExchange 不为空时按兑换手续费的交易查询
+func (wm *WalletManager) GetTransfersFeeEstimated(from string, transfers []cennzTransaction.TransferItem, feeExchange *cennzTransaction.FeeExchange) (*txFeeInfo, error) {
+	feeInfo := &txFeeInfo{}
+
+	fee, err := wm.queryTransfersFee(from, transfers, feeExchange)
+	if err != nil {
+		//节点查询失败时使用固定手续费
+		wm.Log.Warning("query transfers fee failed, use fixed fee ", wm.Config.FixedFee, ", error : ", err)
+		feeInfo.Fee = big.NewInt(wm.Config.FixedFee)
+		return feeInfo, nil
+	}
+
+	feeInfo.Fee = fee
 	return feeInfo, nil
 }
 
+//queryTransferFee 使用空签名构建单笔转账交易估算手续费，用于选择付款地址，
+//创建交易单时会按实际构建的交易单重新查询
+func (wm *WalletManager) queryTransferFee(from string, to string, value *big.Int, assetId string, feeExchange *cennzTransaction.FeeExchange) (*big.Int, error) {
+	fromPub, err := wm.Decoder.AddressDecode(from)
+	if err != nil || len(fromPub) != 32 {
+		return nil, errors.New("wrong from address " + from)
+	}
+
+	//手续费只与交易长度有关，汇总时 to 不是地址，使用发送方代替
+	toPub, err := wm.Decoder.AddressDecode(to)
+	if err != nil || len(toPub) != 32 {
+		toPub = fromPub
+	}
+
+	assetIdUint, err := strconv.ParseUint(assetId, 10, 64)
+	if err != nil {
+		return nil, errors.New("wrong assetId " + assetId)
+	}
+
+	if value == nil {
+		value = big.NewInt(0)
+	}
+
+	return wm.queryTransfersFee(from, []cennzTransaction.TransferItem{
+		{RecipientPubkey: hex.EncodeToString(toPub), Amount: value, AssetId: assetIdUint},
+	}, feeExchange)
+}
+
+//queryTransfersFee 使用空签名构建与实际交易相同调用的交易单估算手续费，多笔转账时使用配置的批量转账调用
+func (wm *WalletManager) queryTransfersFee(from string, transfers []cennzTransaction.TransferItem, feeExchange *cennzTransaction.FeeExchange) (*big.Int, error) {
+	fromPub, err := wm.Decoder.AddressDecode(from)
+	if err != nil || len(fromPub) != 32 {
+		return nil, errors.New("wrong from address " + from)
+	}
+
+	if len(transfers) == 0 {
+		return nil, errors.New("no transfer to estimate")
+	}
+
+	indexes, err := wm.ApiClient.getRuntimeIndexes("")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := cennzTransaction.TxStruct{
+		SenderPubkey:    hex.EncodeToString(fromPub),
+		RecipientPubkey: transfers[0].RecipientPubkey,
+		Amount:          transfers[0].Amount,
+		AssetId:         transfers[0].AssetId,
+		BlockHeight:     1,
+		Period:          wm.Config.EraPeriod,
+		SignatureType:   wm.Config.SignatureType,
+		CallIndex:       indexes.TransferCall,
+		FeeExchange:     feeExchange,
+	}
+
+	if len(transfers) > 1 {
+		tx.BatchCallIndex, err = indexes.GetBatchCall(wm.Config.BatchCall)
+		if err != nil {
+			return nil, err
+		}
+		tx.Transfers = transfers
+	}
+
+	return wm.queryTransactionFee(&tx)
+}
+
+//queryTransactionFee 使用空签名组装交易单，通过 payment_queryInfo 查询手续费，并加上配置的安全余量
+func (wm *WalletManager) queryTransactionFee(tx *cennzTransaction.TxStruct) (*big.Int, error) {
+	emptySignature := hex.EncodeToString(make([]byte, 64))
+	extrinsic, err := tx.GetSignedTransaction(tx.CallIndex, emptySignature)
+	if err != nil {
+		return nil, err
+	}
+	if len(extrinsic) == 0 {
+		return nil, errors.New("create fee query transaction failed")
+	}
+
+	partialFee, err := wm.ApiClient.queryFeeInfo(extrinsic)
+	if err != nil {
+		return nil, err
+	}
+
+	fee := decimal.NewFromBigInt(partialFee, 0).Mul(decimal.New(1, 0).Add(wm.Config.FeeMargin)).Ceil()
+
+	return common.StringNumToBigIntWithExp(fee.String(), 0), nil
+}
+
 // GetAddressNonce
 func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, address string) (uint64, error) {
 	var (
-		key           = wm.Symbol() + "-nonce"
-		nonce         uint64
-		nonce_db      interface{}
-		nonce_onchain uint64
+		key      = wm.Symbol() + "-nonce"
+		nonce    uint64
+		nonce_db interface{}
 	)
 
-	apiAddrBalance, err := wm.ApiClient.getBalance(address, "")
+	nonce_onchain, err := wm.ApiClient.getNonce(address)
 	if err!=nil {
 		return 0, errors.New(address+" get address nonce error : " + err.Error() )
 	}
@@ -197,8 +320,6 @@ func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, address s
 		nonce = common.NewString(nonce_db).UInt64()
 	}
 
-	nonce_onchain = apiAddrBalance.Nonce
-
 	wm.Log.Info(address, " get nonce : ", nonce, ", nonce_onchain : ", nonce_onchain)
 
 	//如果本地nonce_db > 链上nonce,采用本地nonce,否则采用链上nonce
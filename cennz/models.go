@@ -15,14 +15,17 @@
 package cennz
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -88,14 +91,16 @@ type Extrinsic struct {
 
 type Transaction struct {
 	TxID        string
-	Fee         uint64
+	Fee         *big.Int
 	TimeStamp   uint64
 	From        string
 	To          string
-	Amount      uint64
+	Amount      *big.Int            //只有一种资产时为转账总额，多种资产时为 0
+	Amounts     map[string]*big.Int //按资产汇总的转账金额，key 为资产id
 	BlockHeight uint64
 	BlockHash   string
 	Status      string
+	Reason      string //失败原因，解析自 ExtrinsicFailed 的 DispatchError
 	//ToArr       []string //@required 格式："地址":"数量":资产id
 	//ToDecArr    []string //@required 格式："地址":"数量(带小数)":资产id
 	//FromArr     []string //@required 格式："地址":"数量(带小数)":资产id
@@ -103,6 +108,12 @@ type Transaction struct {
 	FromTrxDetailArr     []TrxDetail
 }
 
+//交易池中未打包的交易状态，区别于 openwallet.TxStatusSuccess 和 openwallet.TxStatusFail
+const TxStatusPending = "2"
+
+//所在区块被分叉孤立而撤回的交易状态，区别于链上执行失败的 openwallet.TxStatusFail
+const TxStatusOrphaned = "3"
+
 type TrxDetail struct {
 	Addr        string
 	Amount      string
@@ -169,7 +180,7 @@ func NewBlock(json *gjson.Result, symbol string) *Block {
 	return obj
 }
 
-func NewBlockFromRpc(json *gjson.Result, symbol string) (*Block, error) {
+func NewBlockFromRpc(json *gjson.Result, symbol string, indexes *RuntimeIndexes) (*Block, error) {
 	obj := &Block{}
 	// 解析
 	obj.Hash = gjson.Get(json.Raw, "hash").String()
@@ -177,7 +188,7 @@ func NewBlockFromRpc(json *gjson.Result, symbol string) (*Block, error) {
 	obj.Height = gjson.Get(json.Raw, "number").Uint()
 	obj.Finalized = gjson.Get(json.Raw, "finalized").Bool()
 
-	transactions, blockTime, err := GetTransactionAndBlockTimeInBlock(json, symbol)
+	transactions, blockTime, err := GetTransactionAndBlockTimeInBlock(json, symbol, indexes)
 	if err!=nil {
 		return nil, err
 	}
@@ -202,8 +213,25 @@ func (b *Block) BlockHeader() *openwallet.BlockHeader {
 	return &obj
 }
 
-func GetTransactionAndBlockTimeInBlock(json *gjson.Result, symbol string) ([]Transaction, uint64, error) {
-	transactions := make([]Transaction, 0)
+//blockEvent 交易产生的事件，由不同接口的区块数据转换而来，按名称区分
+type blockEvent struct {
+	ExtrinsicIndex uint64
+	Name           string //如 genericAsset.Transferred，不需要处理的事件为空
+	Data           []gjson.Result
+}
+
+const (
+	eventExtrinsicSuccess = "system.ExtrinsicSuccess"
+	eventExtrinsicFailed  = "system.ExtrinsicFailed"
+	eventTransferred      = "genericAsset.Transferred"
+	eventFeePaid          = "transactionPayment.TransactionFeePaid"
+	eventWithdraw         = "balances.Withdraw"
+)
+
+func GetTransactionAndBlockTimeInBlock(json *gjson.Result, symbol string, indexes *RuntimeIndexes) ([]Transaction, uint64, error) {
+	if indexes == nil {
+		return nil, 0, errors.New("runtime indexes not found")
+	}
 
 	blockHash := gjson.Get(json.Raw, "hash").String()
 	blockHeight := gjson.Get(json.Raw, "number").Uint()
@@ -211,16 +239,14 @@ func GetTransactionAndBlockTimeInBlock(json *gjson.Result, symbol string) ([]Tra
 	blockTime := uint64(time.Now().Unix())
 
 	extrinsicMap := make(map[uint64]Extrinsic)	// key = extrinsicIndex, value = extrinsic
-	transactionMap := make(map[uint64]Transaction)    // key = extrinsicIndex, value = Transaction
 
 	for extrinsicIndex, extrinsicJSON := range gjson.Get(json.Raw, "extrinsics").Array() {
 		section := gjson.Get(extrinsicJSON.Raw, "section").String()
 		method := gjson.Get(extrinsicJSON.Raw, "method").String()
 		isSigned := gjson.Get(extrinsicJSON.Raw, "isSigned").Bool()
 		txid := gjson.Get(extrinsicJSON.Raw, "hash").String()
-		args := gjson.Get(extrinsicJSON.Raw, "args").Array()
 
-		//log.Debug("section : ", section, "method : ", method, ", txid : ", txid, ", isSigned : ", isSigned, ", args : ", args)
+		//log.Debug("section : ", section, "method : ", method, ", txid : ", txid, ", isSigned : ", isSigned)
 
 		//获取这个区块的时间
 		if section == "timestamp" && method=="set" {
@@ -230,379 +256,527 @@ func GetTransactionAndBlockTimeInBlock(json *gjson.Result, symbol string) ([]Tra
 			}
 		}
 
-		if !isSigned {
+		//任何调用产生的转账都从 Transferred 事件中获取，这里只记录签名者和手续费
+		from := ""
+		fee := ""
+		if isSigned {
+			from = gjson.Get(extrinsicJSON.Raw, "signer").String()
+			fee = gjson.Get(extrinsicJSON.Raw, "partialFee").String()
+		}
+
+		extrinsicMap[uint64(extrinsicIndex)] = Extrinsic{
+			Extrinsic_hash:       txid,
+			Call_module:          section,
+			Call_module_function: method,
+			From:                 from,
+			Fee:                  fee,
+			Status:               "0",
+			Index:                uint64(extrinsicIndex),
+		}
+	}
+
+	events := make([]blockEvent, 0)
+	for _, eventJSON := range gjson.Get(json.Raw, "events").Array() {
+		phase := gjson.Get(eventJSON.Raw, "phase")
+		if !phase.Exists() {
+			continue
+		}
+		//只处理交易产生的事件，区块初始化和结束阶段的事件没有对应的交易
+		applyExtrinsic := gjson.Get(phase.Raw, "applyExtrinsic")
+		if !applyExtrinsic.Exists() {
 			continue
 		}
 
-		isSimpleTransfer := section=="genericAsset" && method=="transfer"
-		if isSimpleTransfer {
-			if len( args ) != 3{
-				log.Error("wrong extrinsic args length : ", txid)
-				continue
-			}
+		//事件序号和名称都一致才处理
+		eventIndex := gjson.Get(eventJSON.Raw, "index").String()
+		eventMethod := gjson.Get(eventJSON.Raw, "method").String()
+		name := ""
+		switch {
+		case eventIndex == indexes.ExtrinsicSuccessEvent && eventMethod == "ExtrinsicSuccess":
+			name = eventExtrinsicSuccess
+		case eventIndex == indexes.ExtrinsicFailedEvent && eventMethod == "ExtrinsicFailed":
+			name = eventExtrinsicFailed
+		case eventIndex == indexes.TransferredEvent && eventMethod == "Transferred":
+			name = eventTransferred
+		case len(indexes.FeePaidEvent) > 0 && eventIndex == indexes.FeePaidEvent && eventMethod == "TransactionFeePaid":
+			name = eventFeePaid
+		case len(indexes.WithdrawEvent) > 0 && eventIndex == indexes.WithdrawEvent && eventMethod == "Withdraw":
+			name = eventWithdraw
+		}
+
+		events = append(events, blockEvent{
+			ExtrinsicIndex: applyExtrinsic.Uint(),
+			Name:           name,
+			Data:           gjson.Get(eventJSON.Raw, "data").Array(),
+		})
+	}
+
+	transactions := extractTransactionsByEvents(extrinsicMap, events, blockHash, blockHeight, blockTime, indexes.Metadata)
+
+	return transactions, blockTime, nil
+}
+
+//eventTransfer Transferred 事件中的一笔资金转账
+type eventTransfer struct {
+	AssetId string
+	From    string
+	To      string
+	Amount  *big.Int
+}
 
-			assetId := args[0].String()
-			to := args[1].String()
-			from := gjson.Get(extrinsicJSON.Raw, "signer").String()
-			amount := args[2].String()
+//setTransfers 按资产归类转账明细，Amounts 为各资产的转账总额，只有一种资产时 Amount 为转账总额，To 为所有收款地址
+func (tx *Transaction) setTransfers(transfers []eventTransfer) {
+	assets := make([]string, 0)
+	tx.Amounts = make(map[string]*big.Int)
+	for _, transfer := range transfers {
+		amount, found := tx.Amounts[transfer.AssetId]
+		if !found {
+			assets = append(assets, transfer.AssetId)
+			amount = big.NewInt(0)
+		}
+		tx.Amounts[transfer.AssetId] = new(big.Int).Add(amount, transfer.Amount)
+	}
 
-			if to=="" || amount=="" || assetId=="" || from==""{
-				log.Error("wrong txid : ", txid)
+	tx.ToTrxDetailArr = make([]TrxDetail, 0)
+	tx.FromTrxDetailArr = make([]TrxDetail, 0)
+	recipients := make([]string, 0)
+	for _, assetId := range assets {
+		for _, transfer := range transfers {
+			if transfer.AssetId != assetId {
 				continue
 			}
 
-			toStr := to + ":" + amount + ":" + assetId
-
-			toArr := make([]string, 0)
-			toArr = append(toArr, toStr)
-			fee := gjson.Get(extrinsicJSON.Raw, "partialFee").String()
-
-			extrinsic := Extrinsic{
-				Extrinsic_hash:       txid,
-				Call_module:          section,
-				Call_module_function: method,
-				ToArr:                toArr,
-				ToDecArr:             nil,
-				From:                 from,
-				Fee:                  fee,
-				Status:               "0",
-				Index:                uint64(extrinsicIndex),
+			tx.ToTrxDetailArr = append(tx.ToTrxDetailArr, TrxDetail{
+				Addr:      transfer.To,
+				Amount:    transfer.Amount.String(),
+				AmountDec: "",
+				AssetId:   assetId,
+			})
+			tx.FromTrxDetailArr = append(tx.FromTrxDetailArr, TrxDetail{
+				Addr:      transfer.From,
+				Amount:    transfer.Amount.String(),
+				AmountDec: "",
+				AssetId:   assetId,
+			})
+
+			found := false
+			for _, recipient := range recipients {
+				if recipient == transfer.To {
+					found = true
+					break
+				}
+			}
+			if !found {
+				recipients = append(recipients, transfer.To)
 			}
-
-			extrinsicMap[ uint64(extrinsicIndex) ] = extrinsic
 		}
 	}
 
-	for _, eventJSON := range gjson.Get(json.Raw, "events").Array() {
-		phase := gjson.Get(eventJSON.Raw, "phase")
-		if !phase.Exists() {
+	tx.Amount = big.NewInt(0)
+	if len(assets) == 1 {
+		tx.Amount = tx.Amounts[assets[0]]
+	}
+	tx.To = strings.Join(recipients, ",")
+}
+
+//extractTransactionsByEvents 按交易产生的事件提取交易，批量、嵌套、代理等调用中的转账都来自 Transferred 事件，
+//交易的状态由同一个交易的 ExtrinsicSuccess 或 ExtrinsicFailed 事件确定
+func extractTransactionsByEvents(extrinsicMap map[uint64]Extrinsic, events []blockEvent, blockHash string, blockHeight, blockTime uint64, md *cennzTransaction.RuntimeMetadata) []Transaction {
+	transactions := make([]Transaction, 0)
+	transferMap := make(map[uint64][]eventTransfer) // key = extrinsicIndex, value = Transferred 事件的转账
+	feePaidMap := make(map[uint64]*big.Int)         // key = extrinsicIndex, value = TransactionFeePaid 的实际手续费
+	withdrawMap := make(map[uint64]*big.Int)        // key = extrinsicIndex, value = 签名者 Withdraw 的总额
+
+	for _, event := range events {
+		extrinsicIndex := event.ExtrinsicIndex
+		data := event.Data
+
+		extrinsic, ok := extrinsicMap[extrinsicIndex]
+		if !ok {
 			continue
 		}
-		extrinsicIndex := gjson.Get(phase.Raw, "applyExtrinsic").Uint()
 
-		eventIndex := gjson.Get(eventJSON.Raw, "index").String()
-		eventMethod := gjson.Get(eventJSON.Raw, "method").String()
+		switch event.Name {
+		//扣除手续费的事件在 ExtrinsicSuccess 或 ExtrinsicFailed 之前，先记录下来
+		case eventFeePaid, eventWithdraw:
+			if len(data) < 2 || data[0].String() != extrinsic.From {
+				continue
+			}
+
+			amount, err := parseBigIntAmount(data[1].String())
+			if err != nil {
+				log.Error("wrong fee event txid : ", extrinsic.Extrinsic_hash)
+				continue
+			}
 
-		if eventIndex=="0x0000" && eventMethod=="ExtrinsicSuccess" {	//指明，当前transaction的status可以改为1
-			transaction, ok := transactionMap[extrinsicIndex]
-			if ok {
-				transaction.Status = "1"
-				transactions = append(transactions, transaction)
+			if event.Name == eventFeePaid {
+				feePaidMap[extrinsicIndex] = amount
+			} else if withdrawn, found := withdrawMap[extrinsicIndex]; found {
+				withdrawMap[extrinsicIndex] = new(big.Int).Add(withdrawn, amount)
+			} else {
+				withdrawMap[extrinsicIndex] = amount
 			}
-		}
 
-		if eventIndex=="0x0401" && eventMethod=="Transferred" {
-			extrinsic, ok := extrinsicMap[extrinsicIndex]
-			if ok {
-				if gjson.Get(eventJSON.Raw, "data").Exists()==false {
-					continue
-				}
+		//每个 Transferred 事件对应一笔资金转账，交易成功后才记录
+		case eventTransferred:
+			if len(data) != 4 {
+				log.Error("wrong event args length : ", extrinsic.Extrinsic_hash)
+				continue
+			}
 
-				data := gjson.Get(eventJSON.Raw, "data").Array()
+			assetId := data[0].String()
+			from := data[1].String()
+			to := data[2].String()
 
-				if len(data) != 4{
-					log.Error("wrong event args length : ", extrinsic.Extrinsic_hash)
-					continue
-				}
+			if from == "" || to == "" || assetId == "" {
+				log.Error("wrong event data txid : ", extrinsic.Extrinsic_hash)
+				continue
+			}
+
+			amount, err := parseBigIntAmount(data[3].String())
+			if err != nil {
+				log.Error("wrong amount txid : ", extrinsic.Extrinsic_hash)
+				continue
+			}
 
-				assetId := data[0].String()
-				from := data[1].String()
-				to := data[2].String()
-				amount := data[3].String()
+			transferMap[extrinsicIndex] = append(transferMap[extrinsicIndex], eventTransfer{
+				AssetId: assetId,
+				From:    from,
+				To:      to,
+				Amount:  amount,
+			})
+
+		case eventExtrinsicSuccess:
+			transfers := transferMap[extrinsicIndex]
+			delete(transferMap, extrinsicIndex)
+
+			//ExtrinsicSuccess 的参数为 DispatchInfo
+			var dispatchInfo *gjson.Result
+			if len(data) > 0 {
+				dispatchInfo = &data[0]
+			}
+			fee, err := extrinsicFee(extrinsic, feePaidMap[extrinsicIndex], withdrawMap[extrinsicIndex], dispatchInfo)
+			if err != nil {
+				log.Error("wrong fee txid : ", extrinsic.Extrinsic_hash)
+				continue
+			}
 
-				eventTo := to + ":" + amount + ":" + assetId
+			//没有转账也没有手续费的交易不需要通知
+			if len(transfers) == 0 && fee.Sign() == 0 {
+				continue
+			}
 
-				//普通转账，一个txid，只有一笔资金转账
-				if eventTo != extrinsic.ToArr[0]{
-					log.Error("failed txid : ", extrinsic.Extrinsic_hash)
-					continue
-				}
+			transaction := Transaction{
+				TxID:        extrinsic.Extrinsic_hash,
+				TimeStamp:   blockTime,
+				From:        extrinsic.From,
+				BlockHeight: blockHeight,
+				BlockHash:   blockHash,
+				Status:      openwallet.TxStatusSuccess,
+				Fee:         fee,
+			}
+			transaction.setTransfers(transfers)
 
-				if from == "" {
-					log.Error("from not found txid : ", extrinsic.Extrinsic_hash)
-					continue
-				}
+			//未签名的交易没有签名者，以转出地址为准
+			if len(transaction.From) == 0 {
+				transaction.From = transfers[0].From
+			}
 
-				feeInt, feeErr := strconv.ParseInt(extrinsic.Fee, 10, 64)
-				amountInt, err := strconv.ParseInt(amount, 10, 64)
-				if err == nil  && feeErr == nil{
-					amountUint := uint64(amountInt)
-					fee := uint64(feeInt)
-
-					toTrxDetailArr := make([]TrxDetail, 0)
-					toTrxDetail := TrxDetail{
-						Addr:      to,
-						Amount:    amount,
-						AmountDec: "",
-						AssetId:   assetId,
-					}
-					toTrxDetailArr = append(toTrxDetailArr, toTrxDetail)
-
-					fromTrxDetailArr := make([]TrxDetail, 0)
-					fromTrxDetail := TrxDetail{
-						Addr:      from,
-						Amount:    amount,
-						AmountDec: "",
-						AssetId:   assetId,
-					}
-					fromTrxDetailArr = append(fromTrxDetailArr, fromTrxDetail)
-
-					if feeInt>0 {
-						feeTrxDetail := TrxDetail{
-							Addr:      from,
-							Amount:    extrinsic.Fee,
-							AmountDec: "",
-							AssetId:  feeToken.Address,
-						}
-						fromTrxDetailArr = append(fromTrxDetailArr, feeTrxDetail)
-					}
-
-					transaction := Transaction{
-						TxID:             extrinsic.Extrinsic_hash,
-						TimeStamp:        blockTime,
-						From:             from,
-						To:               to,
-						Amount:           amountUint,
-						BlockHeight:      blockHeight,
-						BlockHash:        blockHash,
-						Status:           "0",
-						ToTrxDetailArr:   toTrxDetailArr,
-						FromTrxDetailArr: fromTrxDetailArr,
-						Fee :             fee,
-					}
-
-					transactionMap[extrinsicIndex] = transaction
-				}
+			//手续费由签名者支付，只记录一次，没有转账时只通知手续费
+			if fee.Sign() > 0 {
+				transaction.FromTrxDetailArr = append(transaction.FromTrxDetailArr, TrxDetail{
+					Addr:      extrinsic.From,
+					Amount:    fee.String(),
+					AmountDec: "",
+					AssetId:   feeToken.Address,
+				})
+			}
+
+			transactions = append(transactions, transaction)
+
+		//失败的交易仍然扣除了手续费，只记录签名者支付的手续费
+		case eventExtrinsicFailed:
+			delete(transferMap, extrinsicIndex)
+
+			if len(extrinsic.From) == 0 {
+				continue
+			}
+
+			if len(data) == 0 {
+				log.Error("wrong event args length : ", extrinsic.Extrinsic_hash)
+				continue
 			}
+
+			//ExtrinsicFailed 的参数为 DispatchError 和 DispatchInfo
+			var dispatchInfo *gjson.Result
+			if len(data) > 1 {
+				dispatchInfo = &data[1]
+			}
+			fee, err := extrinsicFee(extrinsic, feePaidMap[extrinsicIndex], withdrawMap[extrinsicIndex], dispatchInfo)
+			if err != nil {
+				log.Error("wrong fee txid : ", extrinsic.Extrinsic_hash)
+				continue
+			}
+
+			feeTrxDetail := TrxDetail{
+				Addr:      extrinsic.From,
+				Amount:    fee.String(),
+				AmountDec: "",
+				AssetId:   feeToken.Address,
+			}
+
+			transactions = append(transactions, Transaction{
+				TxID:             extrinsic.Extrinsic_hash,
+				TimeStamp:        blockTime,
+				From:             extrinsic.From,
+				Amount:           big.NewInt(0),
+				BlockHeight:      blockHeight,
+				BlockHash:        blockHash,
+				Status:           openwallet.TxStatusFail,
+				Reason:           dispatchErrorReason(&data[0], md),
+				ToTrxDetailArr:   make([]TrxDetail, 0),
+				FromTrxDetailArr: []TrxDetail{feeTrxDetail},
+				Fee:              fee,
+			})
 		}
 	}
 
-	return transactions, blockTime, nil
+	return transactions
 }
 
-func GetTransactionInBlock(json *gjson.Result, symbol string) []Transaction {
-	transactions := make([]Transaction, 0)
+//extrinsicFee 交易实际扣除的手续费，来自同一个交易的 TransactionFeePaid 或 Withdraw 事件，partialFee 只是执行前的估算，
+//运行时没有手续费事件时才使用，DispatchInfo.paysFee 为 No 时不扣手续费
+func extrinsicFee(extrinsic Extrinsic, feePaid, withdrawn *big.Int, dispatchInfo *gjson.Result) (*big.Int, error) {
+	if len(extrinsic.From) == 0 {
+		return big.NewInt(0), nil
+	}
+	if feePaid != nil {
+		return feePaid, nil
+	}
+	if withdrawn != nil {
+		return withdrawn, nil
+	}
 
-	blockHash := gjson.Get(json.Raw, "hash").String()
-	blockHeight := gjson.Get(json.Raw, "block_num").Uint()
+	if dispatchInfo != nil {
+		pays := gjson.Get(dispatchInfo.Raw, "paysFee")
+		if pays.Type == gjson.False || pays.String() == "No" {
+			return big.NewInt(0), nil
+		}
+	}
 
-	blockTime := uint64(time.Now().Unix())
+	return parseBigIntAmount(extrinsic.Fee)
+}
 
-	extrinsicMap := make(map[string]Extrinsic)
+//dispatchErrorReason 解析 DispatchError，模块错误按元数据转为名称，如 {"module":{"index":4,"error":2}} 转为 GenericAsset.InsufficientBalance
+func dispatchErrorReason(dispatchError *gjson.Result, md *cennzTransaction.RuntimeMetadata) string {
+	if dispatchError.Type == gjson.String {
+		return dispatchError.String()
+	}
 
-	for _, extrinsicJSON := range gjson.Get(json.Raw, "extrinsics").Array() {
-		call_module := gjson.Get(extrinsicJSON.Raw, "call_module").String()
-		call_module_function := gjson.Get(extrinsicJSON.Raw, "call_module_function").String()
-		success := gjson.Get(extrinsicJSON.Raw, "success").Bool()
-		//finalized := gjson.Get(extrinsicJSON.Raw, "finalized").Bool()
-		paramsStr := gjson.Get(extrinsicJSON.Raw, "params").String()
+	reason := dispatchError.Raw
+	dispatchError.ForEach(func(key, value gjson.Result) bool {
+		reason = key.String()
+		if value.Type == gjson.String {
+			reason = key.String() + ": " + value.String()
+		}
 
-		txid := gjson.Get(extrinsicJSON.Raw, "extrinsic_hash").String()
+		if !strings.EqualFold(key.String(), "module") {
+			return false
+		}
 
-		//log.Debug("call_module : ", call_module, "call_module_function : ", call_module_function, ", txid : ", txid, ", finalized : ", finalized, ", success : ", success, ", paramsStr : ", paramsStr)
+		//没有元数据时保留模块错误的原始内容
+		if md == nil {
+			reason = "Module: " + value.Raw
+			return false
+		}
 
-		//if !success || !finalized{
-		if !success{
-			continue
+		//新版本的 error 为 4 字节的16进制，第一个字节为错误序号
+		errorIndex := value.Get("error").Uint()
+		if errorHex := value.Get("error").String(); strings.HasPrefix(errorHex, "0x") && len(errorHex) >= 4 {
+			b, err := hex.DecodeString(errorHex[2:4])
+			if err == nil {
+				errorIndex = uint64(b[0])
+			}
 		}
 
-		isSimpleTransfer := call_module=="genericAsset" && call_module_function=="transfer"
-		if isSimpleTransfer {
-			assetId := ""
-			to := ""
-			amount := ""
+		module, name, err := md.FindError(uint8(value.Get("index").Uint()), uint8(errorIndex))
+		if err != nil {
+			reason = "Module: " + value.Raw
+			return false
+		}
+		reason = module + "." + name
+		return false
+	})
 
-			params := gjson.Parse(paramsStr).Array()
-			for _, param := range params {
+	return reason
+}
 
-				if gjson.Get(param.Raw, "name").Exists() == false {
-					continue
-				}
-				paramName := gjson.Get(param.Raw, "name").String()
+//GetTransactionInBlock 解析浏览器接口的区块，转换为交易和事件后与节点接口使用同样的提取方式
+func GetTransactionInBlock(json *gjson.Result, symbol string) []Transaction {
+	blockHash := gjson.Get(json.Raw, "hash").String()
+	blockHeight := gjson.Get(json.Raw, "block_num").Uint()
 
-				if paramName == "asset_id" {
-					if gjson.Get(param.Raw, "value").Exists() == false {
-						continue
-					}
-					assetId = gjson.Get(param.Raw, "value").String()
-				}
-				if paramName == "to" {
-					if gjson.Get(param.Raw, "type").Exists() == false {
-						continue
-					}
-					paramType := gjson.Get(param.Raw, "type").String()
-					if paramType != "AccountId" {
-						continue
-					}
-
-					if gjson.Get(param.Raw, "value").Exists() == false {
-						continue
-					}
-					to = gjson.Get(param.Raw, "value").String()
-				}
-				if paramName == "amount" {
-					if gjson.Get(param.Raw, "type").Exists() == false {
-						continue
-					}
-					paramType := gjson.Get(param.Raw, "type").String()
-					if paramType != "Compact<Balance>" {
-						continue
-					}
-
-					if gjson.Get(param.Raw, "value").Exists() == false {
-						continue
-					}
-					amount = gjson.Get(param.Raw, "value").String()
-				}
+	blockTime := gjson.Get(json.Raw, "block_timestamp").Uint()
+	if blockTime == 0 {
+		blockTime = uint64(time.Now().Unix())
+	}
+
+	extrinsicMap := make(map[uint64]Extrinsic) // key = extrinsicIndex, value = extrinsic
+	hashIndexes := make(map[string]uint64)     // key = txid, value = extrinsicIndex
+
+	for position, extrinsicJSON := range gjson.Get(json.Raw, "extrinsics").Array() {
+		txid := gjson.Get(extrinsicJSON.Raw, "extrinsic_hash").String()
+
+		//extrinsic_index 格式为 区块高度-交易序号
+		extrinsicIndex := uint64(position)
+		if index := gjson.Get(extrinsicJSON.Raw, "extrinsic_index").String(); strings.Contains(index, "-") {
+			i, err := strconv.ParseUint(index[strings.LastIndex(index, "-")+1:], 10, 64)
+			if err == nil {
+				extrinsicIndex = i
 			}
+		}
+
+		//未签名的交易没有签名者和手续费
+		from := gjson.Get(extrinsicJSON.Raw, "account_id").String()
+		fee := ""
+		if len(from) > 0 {
+			fee = gjson.Get(extrinsicJSON.Raw, "fee").String()
+		}
+
+		extrinsicMap[extrinsicIndex] = Extrinsic{
+			Extrinsic_hash:       txid,
+			Call_module:          gjson.Get(extrinsicJSON.Raw, "call_module").String(),
+			Call_module_function: gjson.Get(extrinsicJSON.Raw, "call_module_function").String(),
+			From:                 from,
+			Fee:                  fee,
+			Status:               "0",
+			Index:                extrinsicIndex,
+		}
+		if len(txid) > 0 {
+			hashIndexes[txid] = extrinsicIndex
+		}
+	}
 
-			if to=="" && amount=="" && assetId=="" {
-				log.Error("wrong txid : ", txid)
+	//事件按序号排列，交易的 ExtrinsicSuccess 或 ExtrinsicFailed 在其他事件之后
+	eventsJSON := gjson.Get(json.Raw, "events").Array()
+	sort.SliceStable(eventsJSON, func(i, j int) bool {
+		return gjson.Get(eventsJSON[i].Raw, "event_idx").Uint() < gjson.Get(eventsJSON[j].Raw, "event_idx").Uint()
+	})
+
+	events := make([]blockEvent, 0)
+	for _, eventJSON := range eventsJSON {
+		//只处理交易产生的事件
+		extrinsicIndex, ok := hashIndexes[gjson.Get(eventJSON.Raw, "extrinsic_hash").String()]
+		if !ok {
+			extrinsicIdx := gjson.Get(eventJSON.Raw, "extrinsic_idx")
+			if !extrinsicIdx.Exists() {
 				continue
 			}
+			extrinsicIndex = extrinsicIdx.Uint()
+		}
 
-			toStr := to + ":" + amount + ":" + assetId
-
-			toArr := make([]string, 0)
-			toArr = append(toArr, toStr)
-			fee := gjson.Get(extrinsicJSON.Raw, "fee").String()
-
-			extrinsic := Extrinsic{
-				Extrinsic_hash:       txid,
-				Call_module:          call_module,
-				Call_module_function: call_module_function,
-				ToArr:                toArr,
-				ToDecArr:             nil,
-				From:                 "",
-				Fee:                  fee,
-				Status:               "0",
-			}
+		name := gjson.Get(eventJSON.Raw, "module_id").String() + "." + gjson.Get(eventJSON.Raw, "event_id").String()
+		switch {
+		case strings.EqualFold(name, eventExtrinsicSuccess):
+			name = eventExtrinsicSuccess
+		case strings.EqualFold(name, eventExtrinsicFailed):
+			name = eventExtrinsicFailed
+		case strings.EqualFold(name, eventTransferred):
+			name = eventTransferred
+		case strings.EqualFold(name, eventFeePaid):
+			name = eventFeePaid
+		case strings.EqualFold(name, eventWithdraw):
+			name = eventWithdraw
+		default:
+			name = ""
+		}
 
-			extrinsicMap[txid] = extrinsic
+		//params 为 [{"type":"AssetId","value":"1"},...]，只取参数值
+		data := make([]gjson.Result, 0)
+		for _, param := range gjson.Parse(gjson.Get(eventJSON.Raw, "params").String()).Array() {
+			data = append(data, gjson.Get(param.Raw, "value"))
 		}
+
+		events = append(events, blockEvent{
+			ExtrinsicIndex: extrinsicIndex,
+			Name:           name,
+			Data:           data,
+		})
 	}
 
-	for _, eventJSON := range gjson.Get(json.Raw, "events").Array() {
-		module_id := gjson.Get(eventJSON.Raw, "module_id").String()
-		event_id := gjson.Get(eventJSON.Raw, "event_id").String()
-		//finalized := gjson.Get(eventJSON.Raw, "finalized").Bool()
-
-		isSimpleTransfer := module_id=="genericAsset" && event_id=="Transferred"
-		//if isSimpleTransfer && finalized {
-		if isSimpleTransfer {
-			extrinsic_hash := gjson.Get(eventJSON.Raw, "extrinsic_hash").String()
-			extrinsic, ok := extrinsicMap[extrinsic_hash]
-			if ok {
-				assetId := ""
-				from := ""
-				to := ""
-				amount := ""
-
-				if gjson.Get(eventJSON.Raw, "params").Exists()==false {
-					continue
-				}
-				paramsStr := gjson.Get(eventJSON.Raw, "params").String()
-				params := gjson.Parse(paramsStr).Array()
-				for paramIndex, param := range params {
-					if gjson.Get(param.Raw, "type").Exists() == false {
-						continue
-					}
-					eventType := gjson.Get(param.Raw, "type").String()
-
-					if eventType == "AssetId" {
-						if gjson.Get(param.Raw, "value").Exists() == false {
-							continue
-						}
-						assetId = gjson.Get(param.Raw, "value").String()
-					}
-					if eventType == "AccountId" && paramIndex==1{
-						if gjson.Get(param.Raw, "value").Exists() == false {
-							continue
-						}
-						from = gjson.Get(param.Raw, "value").String()
-					}
-					if eventType == "AccountId" && paramIndex==2{
-						if gjson.Get(param.Raw, "value").Exists() == false {
-							continue
-						}
-						to = gjson.Get(param.Raw, "value").String()
-					}
-					if eventType == "Balance" {
-						if gjson.Get(param.Raw, "value").Exists() == false {
-							continue
-						}
-						amount = gjson.Get(param.Raw, "value").String()
-					}
-				}
+	return extractTransactionsByEvents(extrinsicMap, events, blockHash, blockHeight, blockTime, nil)
+}
 
-				eventTo := to + ":" + amount + ":" + assetId
+// NewPendingTransaction 解析交易池中的签名交易单，只提取转账和由转账组成的批量转账，其他调用返回 nil
+func NewPendingTransaction(extrinsic string, indexes *RuntimeIndexes, decoder openwallet.AddressDecoderV2) (*Transaction, error) {
+	if indexes == nil {
+		return nil, errors.New("runtime indexes not found")
+	}
 
-				//普通转账，一个txid，只有一笔资金转账
-				if eventTo != extrinsic.ToArr[0]{
-					log.Error("failed txid : ", extrinsic.Extrinsic_hash)
-					continue
-				}
+	st, err := cennzTransaction.DecodeSignedTransaction(indexes.TransferCall, extrinsic, indexes.BatchCall, indexes.BatchAllCall)
+	if err != nil {
+		return nil, err
+	}
 
-				if from == "" {
-					log.Error("from not found txid : ", extrinsic.Extrinsic_hash)
-					continue
-				}
+	transfers := st.Transfers
+	if st.CallIndex == indexes.TransferCall {
+		transfers = []cennzTransaction.TransferItem{
+			{RecipientPubkey: st.RecipientPubkey, Amount: st.Amount, AssetId: st.AssetId},
+		}
+	}
+	if len(transfers) == 0 {
+		return nil, nil
+	}
 
-				feeInt, feeErr := strconv.ParseInt(extrinsic.Fee, 10, 64)
-				amountInt, err := strconv.ParseInt(amount, 10, 64)
-				if err == nil  && feeErr == nil{
-					amountUint := uint64(amountInt)
-					fee := uint64(feeInt)
-
-					toTrxDetailArr := make([]TrxDetail, 0)
-					toTrxDetail := TrxDetail{
-						Addr:      to,
-						Amount:    amount,
-						AmountDec: "",
-						AssetId:   assetId,
-					}
-					toTrxDetailArr = append(toTrxDetailArr, toTrxDetail)
-
-					fromTrxDetailArr := make([]TrxDetail, 0)
-					fromTrxDetail := TrxDetail{
-						Addr:      from,
-						Amount:    amount,
-						AmountDec: "",
-						AssetId:   assetId,
-					}
-					fromTrxDetailArr = append(fromTrxDetailArr, fromTrxDetail)
-
-					if feeInt>0 {
-						feeTrxDetail := TrxDetail{
-							Addr:      from,
-							Amount:    extrinsic.Fee,
-							AmountDec: "",
-							AssetId:  feeToken.Address,
-						}
-						fromTrxDetailArr = append(fromTrxDetailArr, feeTrxDetail)
-					}
-
-					transaction := Transaction{
-						TxID:             extrinsic_hash,
-						TimeStamp:        blockTime,
-						From:             from,
-						To:               to,
-						Amount:           amountUint,
-						BlockHeight:      blockHeight,
-						BlockHash:        blockHash,
-						Status:           "1",
-						ToTrxDetailArr:   toTrxDetailArr,
-						FromTrxDetailArr: fromTrxDetailArr,
-						Fee :             fee,
-					}
-
-					transactions = append(transactions, transaction)
-				}
-			}
+	txid, err := cennzTransaction.GetTransactionHash(extrinsic)
+	if err != nil {
+		return nil, err
+	}
+
+	fromPub, _ := hex.DecodeString(st.Signer)
+	from, err := decoder.AddressEncode(fromPub)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]eventTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		toPub, _ := hex.DecodeString(transfer.RecipientPubkey)
+		to, err := decoder.AddressEncode(toPub)
+		if err != nil {
+			return nil, err
 		}
+
+		items = append(items, eventTransfer{
+			AssetId: strconv.FormatUint(transfer.AssetId, 10),
+			From:    from,
+			To:      to,
+			Amount:  transfer.Amount,
+		})
 	}
 
-	return transactions
+	transaction := Transaction{
+		TxID:      txid,
+		Fee:       big.NewInt(0),
+		TimeStamp: uint64(time.Now().Unix()),
+		From:      from,
+		Status:    TxStatusPending,
+	}
+	transaction.setTransfers(items)
+
+	return &transaction, nil
+}
+
+// parseBigIntAmount 解析最小单位的金额，Balance 为 u128，不能使用 int64 解析，空字符串视为 0
+func parseBigIntAmount(amountStr string) (*big.Int, error) {
+	if amountStr == "" {
+		return big.NewInt(0), nil
+	}
+	amount, ok := new(big.Int).SetString(amountStr, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, errors.New("wrong amount " + amountStr)
+	}
+	return amount, nil
 }
 
 // 从最小单位的 amount 转为带小数点的表示
@@ -0,0 +1,260 @@
+package cennz
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+func loadTestBlockJSON(t *testing.T, file string) *gjson.Result {
+	raw, err := ioutil.ReadFile("testdata/" + file)
+	if err != nil {
+		t.Fatal("read test block failed : ", err)
+	}
+	result := gjson.ParseBytes(raw)
+	return &result
+}
+
+func testRuntimeIndexes() *RuntimeIndexes {
+	return &RuntimeIndexes{
+		SpecVersion:           37,
+		TransactionVersion:    5,
+		TransferCall:          "0401",
+		ExtrinsicSuccessEvent: "0x0000",
+		ExtrinsicFailedEvent:  "0x0001",
+		TransferredEvent:      "0x0401",
+	}
+}
+
+func countTrxDetail(details []TrxDetail, addr, amount string) int {
+	count := 0
+	for _, detail := range details {
+		if detail.Addr == addr && detail.Amount == amount {
+			count++
+		}
+	}
+	return count
+}
+
+//checkTestBlockTransactions 两种接口的测试区块包含相同的交易：多种资产的批量转账、代理转账、嵌套批量转账、一笔失败的转账和一笔没有转账的交易
+func checkTestBlockTransactions(t *testing.T, transactions []Transaction) {
+	if len(transactions) != 5 {
+		t.Fatal("wrong transactions : ", len(transactions))
+	}
+
+	//批量转账，一个交易多个 Transferred 事件，明细按资产归类
+	batch := transactions[0]
+	if batch.TxID != "0x0200000000000000000000000000000000000000000000000000000000000000" || batch.Status != openwallet.TxStatusSuccess {
+		t.Error("wrong batch transaction : ", batch.TxID, batch.Status)
+	}
+	if len(batch.ToTrxDetailArr) != 3 || batch.ToTrxDetailArr[0].Addr != "5Bob" || batch.ToTrxDetailArr[1].Addr != "5Dave" || batch.ToTrxDetailArr[2].Addr != "5Charlie" || batch.ToTrxDetailArr[2].AssetId != "16000" {
+		t.Error("wrong batch transfers : ", batch.ToTrxDetailArr)
+	}
+	//多种资产不汇总为一个金额
+	if batch.Amount.Sign() != 0 || len(batch.Amounts) != 2 || batch.Amounts["1"].Int64() != 17 || batch.Amounts["16000"].Int64() != 20 {
+		t.Error("wrong batch amounts : ", batch.Amount, batch.Amounts)
+	}
+	if batch.From != "5Alice" || batch.To != "5Bob,5Dave,5Charlie" || batch.Fee.Int64() != 1000 {
+		t.Error("wrong batch from, to or fee : ", batch.From, batch.To, batch.Fee)
+	}
+	//三笔转账加上签名者的手续费，手续费只记录一次
+	if len(batch.FromTrxDetailArr) != 4 || countTrxDetail(batch.FromTrxDetailArr, "5Alice", "1000") != 1 {
+		t.Error("wrong batch from details : ", batch.FromTrxDetailArr)
+	}
+
+	//代理转账，From 为支付手续费的签名者，转出明细为被代理的账户
+	proxy := transactions[1]
+	if proxy.From != "5Dave" || proxy.To != "5Frank" || proxy.Amount.Int64() != 30 || proxy.Status != openwallet.TxStatusSuccess {
+		t.Error("wrong proxy transaction : ", proxy.From, proxy.To, proxy.Amount)
+	}
+	if len(proxy.FromTrxDetailArr) != 2 || countTrxDetail(proxy.FromTrxDetailArr, "5Eve", "30") != 1 || countTrxDetail(proxy.FromTrxDetailArr, "5Dave", "2000") != 1 {
+		t.Error("wrong proxy from details : ", proxy.FromTrxDetailArr)
+	}
+
+	//嵌套的批量转账
+	nested := transactions[2]
+	if len(nested.ToTrxDetailArr) != 2 || nested.Amount.Int64() != 11 || nested.Status != openwallet.TxStatusSuccess {
+		t.Error("wrong nested batch transaction : ", nested.ToTrxDetailArr, nested.Amount)
+	}
+
+	//失败的交易只记录手续费
+	failed := transactions[3]
+	if failed.TxID != "0x0500000000000000000000000000000000000000000000000000000000000000" || failed.Status != openwallet.TxStatusFail || len(failed.Reason) == 0 {
+		t.Error("wrong failed transaction : ", failed.TxID, failed.Status, failed.Reason)
+	}
+	if len(failed.ToTrxDetailArr) != 0 || len(failed.FromTrxDetailArr) != 1 || failed.FromTrxDetailArr[0].Addr != "5Grace" {
+		t.Error("wrong failed transaction details : ", failed.FromTrxDetailArr)
+	}
+
+	//成功但没有转账的交易只记录签名者的手续费
+	feeOnly := transactions[4]
+	if feeOnly.TxID != "0x0600000000000000000000000000000000000000000000000000000000000000" || feeOnly.Status != openwallet.TxStatusSuccess || feeOnly.Fee.Int64() != 300 {
+		t.Error("wrong fee only transaction : ", feeOnly.TxID, feeOnly.Status, feeOnly.Fee)
+	}
+	if len(feeOnly.ToTrxDetailArr) != 0 || len(feeOnly.FromTrxDetailArr) != 1 || countTrxDetail(feeOnly.FromTrxDetailArr, "5Ivan", "300") != 1 {
+		t.Error("wrong fee only transaction details : ", feeOnly.FromTrxDetailArr)
+	}
+
+	for _, tx := range transactions {
+		if tx.BlockHeight != 100 || tx.BlockHash != "0x8b3a5b5f4c1f0e7a1d9e3a6c2b4f1e0d9c8b7a6f5e4d3c2b1a0f9e8d7c6b5a49" {
+			t.Error("wrong block of transaction : ", tx.TxID, tx.BlockHeight, tx.BlockHash)
+		}
+	}
+}
+
+func TestGetTransactionAndBlockTimeInBlock(t *testing.T) {
+	json := loadTestBlockJSON(t, "block_rpc.json")
+
+	transactions, blockTime, err := GetTransactionAndBlockTimeInBlock(json, Symbol, testRuntimeIndexes())
+	if err != nil {
+		t.Fatal("get transactions failed : ", err)
+	}
+	if blockTime != 1600000000000 {
+		t.Error("wrong block time : ", blockTime)
+	}
+
+	checkTestBlockTransactions(t, transactions)
+
+	//事件序号不一致时不处理，成功的交易只剩手续费
+	indexes := testRuntimeIndexes()
+	indexes.TransferredEvent = "0x0402"
+	transactions, _, err = GetTransactionAndBlockTimeInBlock(json, Symbol, indexes)
+	if err != nil || len(transactions) != 5 {
+		t.Fatal("wrong transactions : ", len(transactions), err)
+	}
+	for _, tx := range transactions {
+		if len(tx.ToTrxDetailArr) != 0 {
+			t.Error("transfers with wrong event index are extracted : ", tx.TxID)
+		}
+	}
+}
+
+func TestGetTransactionInBlock(t *testing.T) {
+	json := loadTestBlockJSON(t, "block_explorer.json")
+
+	//浏览器接口与节点接口提取的交易一致
+	checkTestBlockTransactions(t, GetTransactionInBlock(json, Symbol))
+
+	block := NewBlock(json, Symbol)
+	if block.Height != 100 || block.Timestamp != 1600000000 || len(block.Transactions) != 5 {
+		t.Error("wrong block : ", block.Height, block.Timestamp, len(block.Transactions))
+	}
+}
+
+func testErrorMetadata() *cennzTransaction.RuntimeMetadata {
+	return &cennzTransaction.RuntimeMetadata{
+		Modules: []*cennzTransaction.MetadataModule{
+			{Name: "System", Index: 0, Errors: []string{"InvalidSpecName"}},
+			{Name: "GenericAsset", Index: 4, Errors: []string{"NoIdAvailable", "ZeroAmount", "InsufficientBalance"}},
+		},
+	}
+}
+
+//checkTestFailedBlockTransactions 失败的交易手续费来自同一个交易的手续费事件，而不是 partialFee
+func checkTestFailedBlockTransactions(t *testing.T, transactions []Transaction) {
+	if len(transactions) != 4 {
+		t.Fatal("wrong transactions : ", len(transactions))
+	}
+
+	tests := []struct {
+		txid   string
+		status string
+		fee    int64
+	}{
+		//只有 Withdraw 事件
+		{"0x1200000000000000000000000000000000000000000000000000000000000000", openwallet.TxStatusFail, 700},
+		//TransactionFeePaid 为实际手续费
+		{"0x1300000000000000000000000000000000000000000000000000000000000000", openwallet.TxStatusFail, 810},
+		//成功的交易同样使用实际扣除的手续费，不使用 partialFee
+		{"0x1400000000000000000000000000000000000000000000000000000000000000", openwallet.TxStatusSuccess, 950},
+		//没有手续费事件，paysFee 为 No
+		{"0x1500000000000000000000000000000000000000000000000000000000000000", openwallet.TxStatusFail, 0},
+	}
+
+	for i, test := range tests {
+		tx := transactions[i]
+		if tx.TxID != test.txid || tx.Status != test.status || tx.Fee.Int64() != test.fee {
+			t.Error("wrong transaction : ", tx.TxID, tx.Status, tx.Fee)
+		}
+		if test.status != openwallet.TxStatusFail {
+			continue
+		}
+		if len(tx.Reason) == 0 || len(tx.FromTrxDetailArr) != 1 || tx.FromTrxDetailArr[0].Amount != tx.Fee.String() {
+			t.Error("wrong failed transaction : ", tx.TxID, tx.Reason, tx.FromTrxDetailArr)
+		}
+	}
+
+	if transactions[2].Amount.Int64() != 40 || transactions[2].To != "5Bob" || countTrxDetail(transactions[2].FromTrxDetailArr, "5Alice", "950") != 1 {
+		t.Error("wrong success transaction : ", transactions[2].Amount, transactions[2].To, transactions[2].FromTrxDetailArr)
+	}
+	if transactions[1].Reason != "badOrigin" || transactions[3].Reason != "Other" {
+		t.Error("wrong failed reason : ", transactions[1].Reason, transactions[3].Reason)
+	}
+}
+
+func TestGetTransactionAndBlockTimeInBlockFailed(t *testing.T) {
+	json := loadTestBlockJSON(t, "block_failed_rpc.json")
+
+	indexes := testRuntimeIndexes()
+	indexes.FeePaidEvent = "0x0600"
+	indexes.WithdrawEvent = "0x0508"
+	indexes.Metadata = testErrorMetadata()
+
+	transactions, _, err := GetTransactionAndBlockTimeInBlock(json, Symbol, indexes)
+	if err != nil {
+		t.Fatal("get transactions failed : ", err)
+	}
+
+	checkTestFailedBlockTransactions(t, transactions)
+
+	//模块错误按元数据转为名称
+	if transactions[0].Reason != "GenericAsset.InsufficientBalance" {
+		t.Error("wrong failed reason : ", transactions[0].Reason)
+	}
+}
+
+func TestGetTransactionInBlockFailed(t *testing.T) {
+	json := loadTestBlockJSON(t, "block_failed_explorer.json")
+
+	//浏览器接口同样提取失败的交易
+	transactions := GetTransactionInBlock(json, Symbol)
+
+	checkTestFailedBlockTransactions(t, transactions)
+
+	if transactions[0].Reason != `Module: {"index":4,"error":2}` {
+		t.Error("wrong failed reason : ", transactions[0].Reason)
+	}
+}
+
+func TestDispatchErrorReason(t *testing.T) {
+	md := testErrorMetadata()
+
+	tests := []struct {
+		dispatchError string
+		md            *cennzTransaction.RuntimeMetadata
+		reason        string
+	}{
+		{`"BadOrigin"`, md, "BadOrigin"},
+		{`{"badOrigin":null}`, md, "badOrigin"},
+		{`{"other":"custom error"}`, md, "other: custom error"},
+		{`{"module":{"index":4,"error":2}}`, md, "GenericAsset.InsufficientBalance"},
+		//新版本的 error 为 4 字节的16进制
+		{`{"Module":{"index":4,"error":"0x02000000"}}`, md, "GenericAsset.InsufficientBalance"},
+		{`{"module":{"index":0,"error":0}}`, md, "System.InvalidSpecName"},
+		//元数据中没有的模块或错误
+		{`{"module":{"index":9,"error":0}}`, md, `Module: {"index":9,"error":0}`},
+		{`{"module":{"index":4,"error":7}}`, md, `Module: {"index":4,"error":7}`},
+		{`{"module":{"index":4,"error":2}}`, nil, `Module: {"index":4,"error":2}`},
+	}
+
+	for _, test := range tests {
+		dispatchError := gjson.Parse(test.dispatchError)
+		reason := dispatchErrorReason(&dispatchError, test.md)
+		if reason != test.reason {
+			t.Error("wrong reason of ", test.dispatchError, " : ", reason)
+		}
+	}
+}
@@ -211,8 +211,14 @@ func (c *Client) getBalance(address string, assetId string) (*AddrBalance, error
 		}
 
 		itemAssetId := gjson.Get(balance.Raw, "assetId").String()
-		free := big.NewInt(balance.Get("free").Int())
-		lock := big.NewInt(balance.Get("lock").Int())
+		free, err := parseBigIntAmount(balance.Get("free").String())
+		if err != nil {
+			return nil, err
+		}
+		lock, err := parseBigIntAmount(balance.Get("lock").String())
+		if err != nil {
+			return nil, err
+		}
 
 		if assetId==itemAssetId{
 			addrBalance.AssetId = assetId
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	//节点状态检查结果的缓存时间
+	healthCheckInterval = 10 * time.Second
+)
+
+// NodeHealth 节点的网络和同步状态，来自 system_health 和 system_syncState
+type NodeHealth struct {
+	Peers           uint64
+	IsSyncing       bool
+	ShouldHavePeers bool
+	CurrentBlock    uint64
+	HighestBlock    uint64
+}
+
+// HealthBackend 支持查询节点状态的 backend，未实现时认为节点正常
+type HealthBackend interface {
+	GetHealth() (*NodeHealth, error)
+}
+
+//check 检查节点是否可以使用，不可用时返回原因
+func (h *NodeHealth) check(minPeers, maxBlockLag uint64) error {
+	if h.IsSyncing {
+		return fmt.Errorf("node is syncing, current block %d, highest block %d", h.CurrentBlock, h.HighestBlock)
+	}
+	if h.ShouldHavePeers && h.Peers < minPeers {
+		return fmt.Errorf("node has %d peers, at least %d required", h.Peers, minPeers)
+	}
+	if h.HighestBlock > h.CurrentBlock+maxBlockLag {
+		return fmt.Errorf("node is %d blocks behind the network", h.HighestBlock-h.CurrentBlock)
+	}
+	return nil
+}
+
+//checkHealth 检查所有节点，不可用的节点暂停使用，所有节点都不可用时返回原因，结果缓存 healthCheckInterval
+func (c *ApiClient) checkHealth() error {
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+
+	if time.Since(c.healthCheckedAt) < healthCheckInterval {
+		return c.healthErr
+	}
+
+	healthy := 0
+	reasons := make([]string, 0)
+	for _, node := range c.nodes {
+		err := c.checkNodeHealth(node)
+		if err != nil {
+			node.fail(err)
+			reasons = append(reasons, node.name()+" : "+err.Error())
+			continue
+		}
+		healthy++
+	}
+
+	c.healthErr = nil
+	if healthy == 0 {
+		c.healthErr = errors.New("no healthy node, " + strings.Join(reasons, "; "))
+	}
+	c.healthCheckedAt = time.Now()
+
+	return c.healthErr
+}
+
+func (c *ApiClient) checkNodeHealth(node *apiNode) error {
+	backend, ok := node.backend.(HealthBackend)
+	if !ok {
+		return nil
+	}
+
+	health, err := backend.GetHealth()
+	if err != nil {
+		return err
+	}
+
+	return health.check(c.MinPeers, c.MaxBlockLag)
+}
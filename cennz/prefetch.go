@@ -0,0 +1,127 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"errors"
+)
+
+const (
+	//预先获取的区块数量，获取时与提取交易共用 extractingCH 的并发数
+	maxPrefetchSize = maxExtractingSize
+)
+
+type prefetchResult struct {
+	block *Block
+	err   error
+}
+
+// blockPrefetcher 并发获取后续的区块，按高度顺序返回
+type blockPrefetcher struct {
+	bs   *CENNZBlockScanner
+	next uint64 //下一个返回的高度
+	end  uint64 //本轮获取的最后一个高度
+	out  chan prefetchResult
+	quit chan struct{}
+}
+
+func newBlockPrefetcher(bs *CENNZBlockScanner) *blockPrefetcher {
+	return &blockPrefetcher{bs: bs}
+}
+
+//get 获取指定高度的区块，高度不连续（如分叉重扫）或超出本轮范围时，从该高度到 target 重新开始获取
+func (f *blockPrefetcher) get(height, target uint64) (*Block, error) {
+	if f.out == nil || height != f.next || height > f.end {
+		f.stop()
+		if target < height {
+			target = height
+		}
+		f.start(height, target)
+	}
+
+	result, ok := <-f.out
+	if !ok {
+		return nil, errors.New("block prefetcher stopped")
+	}
+	f.next++
+
+	return result.block, result.err
+}
+
+//start 获取 [start, end] 的区块，最多 maxPrefetchSize 个区块等待取出
+func (f *blockPrefetcher) start(start, end uint64) {
+	var (
+		quit  = make(chan struct{})
+		out   = make(chan prefetchResult)
+		order = make(chan chan prefetchResult, maxPrefetchSize) //按高度排列的获取结果
+		bs    = f.bs
+	)
+
+	f.next = start
+	f.end = end
+	f.out = out
+	f.quit = quit
+
+	//按高度顺序发起获取
+	go func() {
+		defer close(order)
+		for height := start; height <= end; height++ {
+			result := make(chan prefetchResult, 1)
+			select {
+			case order <- result:
+			case <-quit:
+				return
+			}
+
+			select {
+			case bs.extractingCH <- struct{}{}:
+			case <-quit:
+				return
+			}
+
+			go func(height uint64) {
+				block, err := bs.wm.ApiClient.getBlockByHeight(height)
+				<-bs.extractingCH
+				result <- prefetchResult{block: block, err: err}
+			}(height)
+		}
+	}()
+
+	//按高度顺序输出
+	go func() {
+		defer close(out)
+		for result := range order {
+			select {
+			case r := <-result:
+				select {
+				case out <- r:
+				case <-quit:
+					return
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+//stop 停止本轮获取，未取出的区块丢弃
+func (f *blockPrefetcher) stop() {
+	if f.quit != nil {
+		close(f.quit)
+	}
+	f.quit = nil
+	f.out = nil
+}
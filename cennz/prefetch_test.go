@@ -0,0 +1,86 @@
+package cennz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestBlockPrefetcherInOrder(t *testing.T) {
+	bs, backend, _, cleanup := newTestBlockScanner(t, 30, 0, 0)
+	defer cleanup()
+
+	//低高度的区块返回更慢，结果仍需按高度顺序取出
+	backend.delays = map[uint64]time.Duration{
+		1: 50 * time.Millisecond,
+		2: 30 * time.Millisecond,
+		5: 20 * time.Millisecond,
+	}
+
+	prefetcher := newBlockPrefetcher(bs)
+	defer prefetcher.stop()
+
+	for height := uint64(1); height <= 30; height++ {
+		block, err := prefetcher.get(height, 30)
+		if err != nil {
+			t.Fatal("get block failed : ", err)
+		}
+		if block.Height != height || block.Hash != testBlockHash(height, "aa") {
+			t.Fatal("wrong block order : ", height, block.Height)
+		}
+	}
+}
+
+func TestBlockPrefetcherRestart(t *testing.T) {
+	bs, _, _, cleanup := newTestBlockScanner(t, 30, 0, 0)
+	defer cleanup()
+
+	prefetcher := newBlockPrefetcher(bs)
+	defer prefetcher.stop()
+
+	get := func(height, target uint64) {
+		block, err := prefetcher.get(height, target)
+		if err != nil {
+			t.Fatal("get block failed : ", err)
+		}
+		if block.Height != height {
+			t.Fatal("wrong block : ", height, block.Height)
+		}
+	}
+
+	for height := uint64(1); height <= 3; height++ {
+		get(height, 10)
+	}
+
+	//向后跳跃，丢弃未取出的区块重新获取
+	get(7, 10)
+	get(8, 10)
+
+	//分叉重扫，高度回退
+	get(4, 10)
+	get(5, 10)
+
+	//超出本轮范围，目标高度小于请求高度时只获取该高度
+	get(12, 10)
+	get(13, 20)
+}
+
+func TestScanBlockTaskCachesTargetHeight(t *testing.T) {
+	bs, backend, dai, cleanup := newTestBlockScanner(t, 30, 10, 11)
+	defer cleanup()
+
+	dai.current = &openwallet.BlockHeader{Height: 10, Hash: testBlockHash(10, "aa")}
+	bs.Scanning = true
+
+	bs.scanBlockTask()
+
+	if dai.current.Height != 30 || dai.current.Hash != testBlockHash(30, "aa") {
+		t.Fatal("wrong scanned block : ", dai.current.Height, dai.current.Hash)
+	}
+
+	//目标高度只在开始和扫描到目标高度时获取，不是每个区块获取一次
+	if backend.heightCalls != 2 {
+		t.Error("wrong scan target queries : ", backend.heightCalls)
+	}
+}
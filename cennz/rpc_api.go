@@ -17,22 +17,71 @@ package cennz
 import (
 	"errors"
 	"fmt"
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/imroc/req"
 	"github.com/tidwall/gjson"
+	"math/big"
+	"net"
+	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	//http 请求的超时时间
+	rpcHTTPTimeout = 60 * time.Second
+	rpcDialTimeout = 10 * time.Second
+	//每个节点保持的空闲连接数
+	rpcMaxIdleConnsPerHost = 32
+)
+
 type RpcClient struct {
 	BaseURL string
 	Debug   bool
+	//不为空时通过 websocket 长连接发送请求
+	ws *WSClient
+
+	client *req.Req
+	nextID uint64
+}
+
+// RpcRequest 批量请求中的一个调用
+type RpcRequest struct {
+	Method string
+	Params []interface{}
+}
+
+//newRpcHTTPClient 复用连接的 http 客户端，追块时避免每次请求重新建立连接
+func newRpcHTTPClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   rpcDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   rpcMaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   rpcDialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   rpcHTTPTimeout,
+	}
 }
 
 func NewRpcClient(url string, debug bool, symbol string) *RpcClient {
+	api := req.New()
+	api.SetClient(newRpcHTTPClient())
+
 	c := RpcClient{
 		BaseURL: url,
 		Debug: debug,
+		client: api,
 	}
 
 	log.Debug("BaseURL : ", url)
@@ -40,14 +89,39 @@ func NewRpcClient(url string, debug bool, symbol string) *RpcClient {
 	return &c
 }
 
+//NewWSRpcClient 使用 websocket 连接节点，所有请求共用一条长连接
+func NewWSRpcClient(url string, debug bool, symbol string) *RpcClient {
+	c := RpcClient{
+		BaseURL: url,
+		Debug: debug,
+		ws: NewWSClient(url, debug),
+	}
+
+	return &c
+}
+
+//Subscribe 订阅节点推送，只有 websocket 连接支持
+func (c *RpcClient) Subscribe(method, unsubscribeMethod string, params []interface{}) (*Subscription, error) {
+	if c.ws == nil {
+		return nil, errors.New("subscription requires websocket connection")
+	}
+	return c.ws.Subscribe(method, unsubscribeMethod, params)
+}
+
 func (c *RpcClient) Call(method string, params []interface{}) (*gjson.Result, error) {
+	if c.ws != nil {
+		return c.ws.Call(method, params)
+	}
+
 	authHeader := req.Header{
 		"Accept":       "application/json",
 		"Content-Type": "application/json",
 	}
+	id := atomic.AddUint64(&c.nextID, 1)
+
 	body := make(map[string]interface{}, 0)
 	body["jsonrpc"] = "2.0"
-	body["id"] = 1
+	body["id"] = id
 	body["method"] = method
 	body["params"] = params
 
@@ -55,7 +129,7 @@ func (c *RpcClient) Call(method string, params []interface{}) (*gjson.Result, er
 		log.Debug("url : ", c.BaseURL, ", body : ", body)
 	}
 
-	r, err := req.Post(c.BaseURL, req.BodyJSON(&body), authHeader)
+	r, err := c.client.Post(c.BaseURL, req.BodyJSON(&body), authHeader)
 
 	if c.Debug {
 		log.Debugf("%+v\n", r)
@@ -71,11 +145,112 @@ func (c *RpcClient) Call(method string, params []interface{}) (*gjson.Result, er
 		return nil, err
 	}
 
+	if resp.Get("id").Uint() != id {
+		return nil, fmt.Errorf("wrong response id, expect %d, got %s", id, resp.Get("id").Raw)
+	}
+
 	result := resp.Get("result")
 
 	return &result, nil
 }
 
+//BatchCall 一次请求发送多个调用，按 id 对应响应，结果与 requests 顺序一致，任一调用出错时返回错误
+func (c *RpcClient) BatchCall(requests []RpcRequest) ([]*gjson.Result, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	if c.ws != nil {
+		return c.ws.BatchCall(requests)
+	}
+
+	authHeader := req.Header{
+		"Accept":       "application/json",
+		"Content-Type": "application/json",
+	}
+
+	indexes := make(map[uint64]int, len(requests)) // key = id, value = 请求序号
+	body := make([]map[string]interface{}, 0, len(requests))
+	for i, request := range requests {
+		id := atomic.AddUint64(&c.nextID, 1)
+		indexes[id] = i
+
+		params := request.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+
+		body = append(body, map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      id,
+			"method":  request.Method,
+			"params":  params,
+		})
+	}
+
+	if c.Debug {
+		log.Debug("url : ", c.BaseURL, ", body : ", body)
+	}
+
+	r, err := c.client.Post(c.BaseURL, req.BodyJSON(&body), authHeader)
+
+	if c.Debug {
+		log.Debugf("%+v\n", r)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp := gjson.ParseBytes(r.Bytes())
+	if !resp.IsArray() {
+		//整个批量请求无效时，节点返回单个错误
+		err = isError(&resp)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("batch response is not an array")
+	}
+
+	results := make([]*gjson.Result, len(requests))
+	for _, item := range resp.Array() {
+		i, ok := indexes[item.Get("id").Uint()]
+		if !ok {
+			continue
+		}
+
+		err = isError(&item)
+		if err != nil {
+			return nil, err
+		}
+
+		result := item.Get("result")
+		results[i] = &result
+	}
+
+	for i, result := range results {
+		if result == nil {
+			return nil, errors.New("response not found in batch : " + requests[i].Method)
+		}
+	}
+
+	return results, nil
+}
+
+//rpcError 节点返回的 JSON-RPC 错误，说明节点可用，不需要切换节点
+type rpcError struct {
+	message string
+}
+
+func (e *rpcError) Error() string {
+	return e.message
+}
+
+func isRpcError(err error) bool {
+	_, ok := err.(*rpcError)
+	return ok
+}
+
 //isError 是否报错
 func isError(result *gjson.Result) error {
 	var (
@@ -94,16 +269,24 @@ func isError(result *gjson.Result) error {
 	errInfo := fmt.Sprintf("[%d]%s",
 		result.Get("error.code").Int(),
 		result.Get("error.message").String()+" - "+result.Get("error.data").String())
-	err = errors.New(errInfo)
+	err = &rpcError{message: errInfo}
 
 	return err
 }
 
 func (c *RpcClient) GetRuntimeVersion() (*RuntimeVersion, error) {
+	return c.GetRuntimeVersionAt("")
+}
+
+// 获取指定区块的运行时版本，blockHash 为空时为最新区块
+func (c *RpcClient) GetRuntimeVersionAt(blockHash string) (*RuntimeVersion, error) {
 	method := "state_getRuntimeVersion"
 
 	params := []interface{}{
 	}
+	if len(blockHash) > 0 {
+		params = append(params, blockHash)
+	}
 
 	resp, err := c.Call(method, params)
 	if err != nil {
@@ -118,6 +301,115 @@ func (c *RpcClient) GetRuntimeVersion() (*RuntimeVersion, error) {
 	return result, nil
 }
 
+// 获取指定区块的元数据，blockHash 为空时为最新区块
+func (c *RpcClient) GetMetadata(blockHash string) (string, error) {
+	method := "state_getMetadata"
+
+	params := []interface{}{
+	}
+	if len(blockHash) > 0 {
+		params = append(params, blockHash)
+	}
+
+	resp, err := c.Call(method, params)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.String(), nil
+}
+
+// 获取存储项，blockHash 为空时为最新区块，存储项不存在时返回空字符串
+func (c *RpcClient) GetStorage(key, blockHash string) (string, error) {
+	method := "state_getStorage"
+
+	params := []interface{}{
+		key,
+	}
+	if len(blockHash) > 0 {
+		params = append(params, blockHash)
+	}
+
+	resp, err := c.Call(method, params)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.String(), nil
+}
+
+// 从链上存储读取地址余额和nonce，存储键按元数据生成
+func (c *RpcClient) getStorageBalance(md *cennzTransaction.RuntimeMetadata, address, blockHash, assetId string) (*AddrBalance, error) {
+	if assetId=="" {
+		assetId = "1"
+	}
+
+	assetIdUint, err := strconv.ParseUint(assetId, 10, 32)
+	if err != nil {
+		return nil, errors.New("wrong assetId " + assetId)
+	}
+
+	pubkey, err := Default.AddressDecode(address)
+	if err != nil || len(pubkey) != 32 {
+		return nil, errors.New("wrong address " + address)
+	}
+
+	assetKey := cennzTransaction.EncodeAssetIdKey(assetIdUint)
+
+	freeKey, err := md.CreateStorageKey("genericAsset", "FreeBalance", assetKey, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	reservedKey, err := md.CreateStorageKey("genericAsset", "ReservedBalance", assetKey, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	accountKey, err := md.CreateStorageKey("system", "Account", pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	freeValue, err := c.GetStorage(freeKey, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	free, err := cennzTransaction.DecodeStorageBalance(freeValue)
+	if err != nil {
+		return nil, err
+	}
+
+	reservedValue, err := c.GetStorage(reservedKey, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := cennzTransaction.DecodeStorageBalance(reservedValue)
+	if err != nil {
+		return nil, err
+	}
+
+	accountValue, err := c.GetStorage(accountKey, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := cennzTransaction.DecodeStorageAccountNonce(accountValue)
+	if err != nil {
+		return nil, err
+	}
+
+	addrBalance := &AddrBalance{
+		Address: address,
+		AssetId: assetId,
+		Balance: free,
+		Free:    free,
+		Freeze:  reserved,
+		Nonce:   nonce,
+		index:   0,
+		Actived: len(accountValue) > 0,
+	}
+
+	return addrBalance, nil
+}
+
 func (c *RpcClient) GetGenesisHash() (string, error) {
 	method := "chain_getBlockHash"
 
@@ -145,8 +437,6 @@ func (c *RpcClient) sendTransaction(rawTx string) (string, error) {
 		return "", err
 	}
 
-	time.Sleep(time.Duration(1) * time.Second)
-
 	log.Debug("sendTransaction result : ", resp)
 
 	if resp.Get("error").String() != "" && resp.Get("cause").String() != "" {
@@ -156,6 +446,57 @@ func (c *RpcClient) sendTransaction(rawTx string) (string, error) {
 	return resp.String(), nil
 }
 
+//SubmitAndWatchExtrinsic 广播交易并订阅交易单的状态变化，只有 websocket 连接支持
+func (c *RpcClient) SubmitAndWatchExtrinsic(rawTx string) (*Subscription, error) {
+	method := "author_submitAndWatchExtrinsic"
+
+	params := []interface{}{
+		rawTx,
+	}
+
+	return c.Subscribe(method, "author_unwatchExtrinsic", params)
+}
+
+// 查询交易的手续费，交易可以使用空签名
+func (c *RpcClient) QueryFeeInfo(extrinsic string) (*big.Int, error) {
+	method := "payment_queryInfo"
+
+	params := []interface{}{
+		extrinsic,
+	}
+
+	resp, err := c.Call(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Get("partialFee").Exists() {
+		return nil, errors.New("partialFee not found")
+	}
+
+	return parseBigIntAmount(resp.Get("partialFee").String())
+}
+
+// 获取交易池中等待打包的交易单
+func (c *RpcClient) GetPendingExtrinsics() ([]string, error) {
+	method := "author_pendingExtrinsics"
+
+	params := []interface{}{
+	}
+
+	resp, err := c.Call(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	extrinsics := make([]string, 0)
+	for _, extrinsic := range resp.Array() {
+		extrinsics = append(extrinsics, extrinsic.String())
+	}
+
+	return extrinsics, nil
+}
+
 // 获取当前最高区块
 func (c *RpcClient) GetBlockHash(height uint64) (string, error) {
 	method := "chain_getBlockHash"
@@ -198,7 +539,92 @@ func (c *RpcClient) getBlockHeight() (uint64, error) {
 		return 0, err
 	}
 
-	numberStr := resp.Get("number").String()
+	return parseBlockNumber(resp)
+}
+
+// 获取已确认的最新区块，只有高度和hash
+func (c *RpcClient) getFinalizedBlock() (*Block, error) {
+	hash, err := c.GetFinalizedHead()
+	if err != nil {
+		return nil, err
+	}
+
+	method := "chain_getHeader"
+
+	params := []interface{}{
+		hash,
+	}
+
+	resp, err := c.Call(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	height, err := parseBlockNumber(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Block{}
+	obj.Hash = hash
+	obj.PrevBlockHash = resp.Get("parentHash").String()
+	obj.Height = height
+
+	return obj, nil
+}
+
+// blockInfo 扫描区块时通过一次批量请求获取的链上信息
+type blockInfo struct {
+	Hash           string          //指定高度的区块hash
+	FinalizedHead  string          //已确认的最新区块hash
+	RuntimeVersion *RuntimeVersion //blockHash 所在的运行时版本
+}
+
+//getBlockInfo 一次批量请求获取指定高度的区块hash、已确认的最新区块hash和 blockHash 所在的运行时版本
+func (c *RpcClient) getBlockInfo(height uint64, blockHash string) (*blockInfo, error) {
+	results, err := c.BatchCall([]RpcRequest{
+		{Method: "chain_getBlockHash", Params: []interface{}{height}},
+		{Method: "chain_getFinalizedHead"},
+		{Method: "state_getRuntimeVersion", Params: []interface{}{blockHash}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeVersion, err := GetRuntimeVersion(results[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &blockInfo{
+		Hash:           results[0].String(),
+		FinalizedHead:  results[1].String(),
+		RuntimeVersion: runtimeVersion,
+	}, nil
+}
+
+//GetHealth 一次批量请求获取节点的 peer 数量和同步状态
+func (c *RpcClient) GetHealth() (*NodeHealth, error) {
+	results, err := c.BatchCall([]RpcRequest{
+		{Method: "system_health"},
+		{Method: "system_syncState"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeHealth{
+		Peers:           results[0].Get("peers").Uint(),
+		IsSyncing:       results[0].Get("isSyncing").Bool(),
+		ShouldHavePeers: results[0].Get("shouldHavePeers").Bool(),
+		CurrentBlock:    results[1].Get("currentBlock").Uint(),
+		HighestBlock:    results[1].Get("highestBlock").Uint(),
+	}, nil
+}
+
+//parseBlockNumber 解析区块头中16进制的高度
+func parseBlockNumber(header *gjson.Result) (uint64, error) {
+	numberStr := header.Get("number").String()
 	if len(numberStr) > 2 {
 		numberStr = numberStr[2:]
 	}
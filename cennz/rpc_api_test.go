@@ -53,4 +53,92 @@ func Test_SendTransaction(t *testing.T) {
 	} else {
 		fmt.Println("r:", r)
 	}
-}
\ No newline at end of file
+}
+func Test_GetRuntimeIndexes(t *testing.T) {
+
+	c := NewRpcClient(testRpcAPI, true, symbol)
+
+	v, err := c.GetRuntimeVersionAt("")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	metadata, err := c.GetMetadata("")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	r, err := NewRuntimeIndexes(v, metadata)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("transfer:", r.TransferCall, ", success:", r.ExtrinsicSuccessEvent, ", transferred:", r.TransferredEvent)
+	}
+}
+
+func Test_GetStorageBalance(t *testing.T) {
+
+	c := NewRpcClient(testRpcAPI, true, symbol)
+
+	v, err := c.GetRuntimeVersionAt("")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	metadata, err := c.GetMetadata("")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	r, err := NewRuntimeIndexes(v, metadata)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	balance, err := c.getStorageBalance(r.Metadata, "5FTRqkH3PzVu4Ay3eegZXMm6dAMfdW8LqvGaiaY2B6qGPKp6", "", "1")
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("free:", balance.Free, ", reserved:", balance.Freeze, ", nonce:", balance.Nonce)
+	}
+}
+
+func Test_BatchCall(t *testing.T) {
+
+	c := NewRpcClient(testRpcAPI, true, symbol)
+
+	results, err := c.BatchCall([]RpcRequest{
+		{Method: "chain_getBlockHash", Params: []interface{}{0}},
+		{Method: "chain_getFinalizedHead"},
+		{Method: "state_getRuntimeVersion"},
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, result := range results {
+		fmt.Println("result:", result.String())
+	}
+}
+
+func Test_GetHealth(t *testing.T) {
+
+	c := NewRpcClient(testRpcAPI, true, symbol)
+
+	health, err := c.GetHealth()
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("peers:", health.Peers, ", syncing:", health.IsSyncing, ", current:", health.CurrentBlock, ", highest:", health.HighestBlock)
+	fmt.Println("check:", health.check(1, 10))
+}
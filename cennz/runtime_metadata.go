@@ -0,0 +1,169 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package cennz
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+//RuntimeIndexes 由元数据按名称解析出的调用和事件序号，运行时升级后模块顺序可能变化
+type RuntimeIndexes struct {
+	SpecVersion        uint32
+	TransactionVersion uint32
+	Metadata           *cennzTransaction.RuntimeMetadata
+
+	//genericAsset.transfer，如 0401
+	TransferCall string
+	//system.ExtrinsicSuccess，如 0x0000
+	ExtrinsicSuccessEvent string
+	//system.ExtrinsicFailed，如 0x0001
+	ExtrinsicFailedEvent string
+	//genericAsset.Transferred，如 0x0401
+	TransferredEvent string
+	//transactionPayment.TransactionFeePaid 和 balances.Withdraw，扣除手续费时产生，运行时不支持时为空
+	FeePaidEvent  string
+	WithdrawEvent string
+	//utility.batch 和 utility.batch_all，运行时不支持时为空
+	BatchCall    string
+	BatchAllCall string
+}
+
+//NewRuntimeIndexes 解析元数据并按名称查找序号
+func NewRuntimeIndexes(runtimeVersion *RuntimeVersion, metadataHex string) (*RuntimeIndexes, error) {
+	md, err := cennzTransaction.DecodeRuntimeMetadata(metadataHex)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := &RuntimeIndexes{
+		SpecVersion:        runtimeVersion.SpecVersion,
+		TransactionVersion: runtimeVersion.TransactionVersion,
+		Metadata:           md,
+	}
+
+	indexes.TransferCall, err = md.FindCallIndex("genericAsset", "transfer")
+	if err != nil {
+		return nil, err
+	}
+
+	successEvent, err := md.FindEventIndex("system", "ExtrinsicSuccess")
+	if err != nil {
+		return nil, err
+	}
+	indexes.ExtrinsicSuccessEvent = "0x" + successEvent
+
+	failedEvent, err := md.FindEventIndex("system", "ExtrinsicFailed")
+	if err != nil {
+		return nil, err
+	}
+	indexes.ExtrinsicFailedEvent = "0x" + failedEvent
+
+	transferredEvent, err := md.FindEventIndex("genericAsset", "Transferred")
+	if err != nil {
+		return nil, err
+	}
+	indexes.TransferredEvent = "0x" + transferredEvent
+
+	if feePaidEvent, err := md.FindEventIndex("transactionPayment", "TransactionFeePaid"); err == nil {
+		indexes.FeePaidEvent = "0x" + feePaidEvent
+	}
+	if withdrawEvent, err := md.FindEventIndex("balances", "Withdraw"); err == nil {
+		indexes.WithdrawEvent = "0x" + withdrawEvent
+	}
+
+	indexes.BatchCall, _ = md.FindCallIndex("utility", "batch")
+	indexes.BatchAllCall, _ = md.FindCallIndex("utility", "batch_all")
+
+	return indexes, nil
+}
+
+//runtimeIndexesCache 按 specVersion 缓存的元数据序号
+type runtimeIndexesCache struct {
+	lock    sync.Mutex
+	indexes map[uint32]*RuntimeIndexes
+}
+
+func newRuntimeIndexesCache() *runtimeIndexesCache {
+	return &runtimeIndexesCache{
+		indexes: make(map[uint32]*RuntimeIndexes),
+	}
+}
+
+//getAt 获取指定区块的运行时序号，blockHash 为空时为最新区块，运行时版本和元数据从同一个 backend 获取
+func (cache *runtimeIndexesCache) getAt(backend ChainBackend, blockHash string) (*RuntimeIndexes, error) {
+	runtimeVersion, err := backend.GetRuntimeVersion(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.get(backend, runtimeVersion, blockHash)
+}
+
+//get 已知运行时版本时直接查缓存，缓存中没有时才获取元数据
+func (cache *runtimeIndexesCache) get(backend ChainBackend, runtimeVersion *RuntimeVersion, blockHash string) (*RuntimeIndexes, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if indexes, ok := cache.indexes[runtimeVersion.SpecVersion]; ok {
+		return indexes, nil
+	}
+
+	metadataHex, err := backend.GetMetadata(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := NewRuntimeIndexes(runtimeVersion, metadataHex)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("runtime metadata loaded, specVersion : ", indexes.SpecVersion, ", transfer : ", indexes.TransferCall, ", transferred : ", indexes.TransferredEvent)
+
+	cache.indexes[runtimeVersion.SpecVersion] = indexes
+
+	return indexes, nil
+}
+
+//getRuntimeIndexes 获取指定区块的运行时序号，按 specVersion 缓存，blockHash 为空时为最新区块
+func (c *ApiClient) getRuntimeIndexes(blockHash string) (*RuntimeIndexes, error) {
+	var (
+		indexes *RuntimeIndexes
+	)
+	err := c.do(func(node *apiNode) error {
+		var err error
+		indexes, err = c.runtimeIndexes.getAt(node.backend, blockHash)
+		return err
+	})
+
+	return indexes, err
+}
+
+//GetBatchCall 按配置返回批量转账使用的调用序号
+func (indexes *RuntimeIndexes) GetBatchCall(batchCall string) (string, error) {
+	callIndex := indexes.BatchAllCall
+	if batchCall == BatchCallBatch {
+		callIndex = indexes.BatchCall
+	}
+	if callIndex == "" {
+		return "", fmt.Errorf("utility.%s is not supported by runtime %d", batchCall, indexes.SpecVersion)
+	}
+	return callIndex, nil
+}
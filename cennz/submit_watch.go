@@ -0,0 +1,334 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	//提交的交易单状态记录文件
+	submittedFile = "submitted.db"
+)
+
+var (
+	//订阅超过该时间仍未进入最终状态时改为轮询，轮询超过该时间后停止跟踪
+	submitWatchTimeout = 10 * time.Minute
+	//订阅断开、超时或重启后按交易hash轮询的间隔
+	submitPollInterval = 6 * time.Second
+)
+
+//交易单在交易池和链上的状态，与 author_submitAndWatchExtrinsic 的推送一致
+const (
+	SubmitStatusSubmitted       = "submitted" //已提交，还未收到推送
+	SubmitStatusFuture          = "future"
+	SubmitStatusReady           = "ready"
+	SubmitStatusBroadcast       = "broadcast"
+	SubmitStatusInBlock         = "inBlock"
+	SubmitStatusRetracted       = "retracted"
+	SubmitStatusFinalityTimeout = "finalityTimeout"
+	SubmitStatusFinalized       = "finalized"
+	SubmitStatusUsurped         = "usurped"
+	SubmitStatusDropped         = "dropped"
+	SubmitStatusInvalid         = "invalid"
+)
+
+// SubmittedTransaction 通过 author_submitAndWatchExtrinsic 提交的交易单及其状态变化
+type SubmittedTransaction struct {
+	TxID      string            `json:"txid" storm:"id"`
+	Status    string            `json:"status"`
+	BlockHash string            `json:"blockHash"` //inBlock、finalized 所在区块，usurped 时为替代交易的hash
+	Watching  bool              `json:"watching"`  //是否仍在跟踪
+	Height    uint64            `json:"height"`    //提交时已确认的区块高度，轮询时从该高度之后查找
+	Checked   uint64            `json:"checked"`   //轮询已查找到的区块高度
+	History   []SubmittedStatus `json:"history"`
+	CreateAt  int64             `json:"createAt"`
+	UpdateAt  int64             `json:"updateAt"`
+}
+
+// SubmittedStatus 一次状态变化
+type SubmittedStatus struct {
+	Status    string `json:"status"`
+	BlockHash string `json:"blockHash"`
+	Time      int64  `json:"time"`
+}
+
+//isFinalSubmitStatus 进入该状态后节点不再推送
+func isFinalSubmitStatus(status string) bool {
+	switch status {
+	case SubmitStatusFinalized, SubmitStatusFinalityTimeout, SubmitStatusUsurped, SubmitStatusDropped, SubmitStatusInvalid:
+		return true
+	}
+	return false
+}
+
+//parseSubmitStatus 解析推送的状态，如 "ready"、{"inBlock":"0x..."}、{"broadcast":["peer"]}
+func parseSubmitStatus(result *gjson.Result) (string, string) {
+	if result.Type == gjson.String {
+		return result.String(), ""
+	}
+
+	status, blockHash := "", ""
+	result.ForEach(func(key, value gjson.Result) bool {
+		status = key.String()
+		if value.Type == gjson.String {
+			blockHash = value.String()
+		}
+		return false
+	})
+	return status, blockHash
+}
+
+//submitAndWatchTransaction 广播交易并在后台跟踪状态变化，状态记录到本地数据库
+func (wm *WalletManager) submitAndWatchTransaction(txHex string) (string, error) {
+	txid, err := cennzTransaction.GetTransactionHash(txHex)
+	if err != nil {
+		return "", err
+	}
+
+	//订阅断开后从提交时的已确认高度开始查找交易
+	var height uint64
+	finalizedBlock, err := wm.ApiClient.getFinalizedBlock()
+	if err != nil {
+		wm.Log.Warning("get finalized block failed, txid : ", txid, ", error : ", err)
+	} else {
+		height = finalizedBlock.Height
+	}
+
+	sub, err := wm.ApiClient.submitAndWatchTransaction(txHex)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	record := &SubmittedTransaction{
+		TxID:     txid,
+		Status:   SubmitStatusSubmitted,
+		Watching: true,
+		Height:   height,
+		History:  []SubmittedStatus{{Status: SubmitStatusSubmitted, Time: now}},
+		CreateAt: now,
+		UpdateAt: now,
+	}
+	err = wm.saveSubmittedTransaction(record)
+	if err != nil {
+		wm.Log.Error("save submitted transaction failed, txid : ", txid, ", error : ", err)
+	}
+
+	go wm.watchSubmittedTransaction(record, sub)
+
+	return txid, nil
+}
+
+//watchSubmittedTransaction 记录每一次状态变化，进入最终状态后取消订阅，订阅被关闭或超时后改为轮询
+func (wm *WalletManager) watchSubmittedTransaction(record *SubmittedTransaction, sub *Subscription) {
+	timeout := time.After(submitWatchTimeout)
+	for {
+		select {
+		case result, ok := <-sub.C:
+			if !ok {
+				wm.Log.Warning("submitted transaction watching closed, poll by txid : ", record.TxID)
+				sub.Unsubscribe()
+				wm.pollSubmittedTransaction(record, time.Unix(record.UpdateAt, 0).Add(submitWatchTimeout))
+				return
+			}
+
+			status, blockHash := parseSubmitStatus(result)
+			if len(status) == 0 {
+				continue
+			}
+
+			wm.updateSubmittedStatus(record, status, blockHash)
+
+			if !record.Watching {
+				sub.Unsubscribe()
+				return
+			}
+		case <-timeout:
+			//推送可能丢失，按交易hash确认最终状态，不保留中间状态
+			wm.Log.Warning("submitted transaction watching timeout, poll by txid : ", record.TxID, ", status : ", record.Status)
+			sub.Unsubscribe()
+			wm.pollSubmittedTransaction(record, time.Now().Add(submitWatchTimeout))
+			return
+		}
+	}
+}
+
+//updateSubmittedStatus 记录一次状态变化
+func (wm *WalletManager) updateSubmittedStatus(record *SubmittedTransaction, status, blockHash string) {
+	now := time.Now().Unix()
+	record.Status = status
+	if len(blockHash) > 0 {
+		record.BlockHash = blockHash
+	}
+	record.History = append(record.History, SubmittedStatus{Status: status, BlockHash: blockHash, Time: now})
+	record.UpdateAt = now
+	record.Watching = !isFinalSubmitStatus(status)
+
+	wm.Log.Info("submitted transaction status, txid : ", record.TxID, ", status : ", status, " ", blockHash)
+
+	err := wm.saveSubmittedTransaction(record)
+	if err != nil {
+		wm.Log.Error("save submitted transaction failed, txid : ", record.TxID, ", error : ", err)
+	}
+}
+
+//pollSubmittedTransaction 按交易hash在已确认区块和交易池中查找，直到进入最终状态或超过 deadline
+//订阅不能在断线后重新发起，否则会重复广播交易
+func (wm *WalletManager) pollSubmittedTransaction(record *SubmittedTransaction, deadline time.Time) {
+	for {
+		err := wm.checkSubmittedTransaction(record)
+		if err != nil {
+			wm.Log.Warning("poll submitted transaction failed, txid : ", record.TxID, ", error : ", err)
+		}
+		if !record.Watching {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			wm.Log.Warning("submitted transaction watching timeout, txid : ", record.TxID, ", status : ", record.Status)
+			record.Watching = false
+			wm.saveSubmittedTransaction(record)
+			return
+		}
+
+		time.Sleep(submitPollInterval)
+	}
+}
+
+//checkSubmittedTransaction 查找交易是否已打包进已确认区块，否则检查是否仍在交易池中
+func (wm *WalletManager) checkSubmittedTransaction(record *SubmittedTransaction) error {
+	finalizedBlock, err := wm.ApiClient.getFinalizedBlock()
+	if err != nil {
+		return err
+	}
+
+	if record.Height == 0 {
+		record.Height = finalizedBlock.Height
+	}
+	if record.Checked < record.Height {
+		record.Checked = record.Height
+	}
+
+	txid := RemoveOxToAddress(strings.ToLower(record.TxID))
+	for height := record.Checked + 1; height <= finalizedBlock.Height; height++ {
+		block, err := wm.ApiClient.getBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range block.Transactions {
+			if RemoveOxToAddress(strings.ToLower(tx.TxID)) == txid {
+				wm.updateSubmittedStatus(record, SubmitStatusFinalized, block.Hash)
+				return nil
+			}
+		}
+		record.Checked = height
+	}
+
+	pending, err := wm.ApiClient.getPendingExtrinsics()
+	if err != nil {
+		return err
+	}
+	for _, extrinsic := range pending {
+		hash, err := cennzTransaction.GetTransactionHash(extrinsic)
+		if err == nil && RemoveOxToAddress(hash) == txid {
+			if record.Status == SubmitStatusSubmitted {
+				wm.updateSubmittedStatus(record, SubmitStatusReady, "")
+			}
+			return wm.saveSubmittedTransaction(record)
+		}
+	}
+
+	return wm.saveSubmittedTransaction(record)
+}
+
+//resumeSubmittedTransactions 启动时继续跟踪上次未进入最终状态的交易单
+func (wm *WalletManager) resumeSubmittedTransactions() error {
+	records, err := wm.getWatchingSubmittedTransactions()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		wm.Log.Info("resume watching submitted transaction, txid : ", record.TxID, ", status : ", record.Status)
+		go wm.pollSubmittedTransaction(record, time.Unix(record.UpdateAt, 0).Add(submitWatchTimeout))
+	}
+
+	return nil
+}
+
+func (wm *WalletManager) getWatchingSubmittedTransactions() ([]*SubmittedTransaction, error) {
+	wm.submittedLock.Lock()
+	defer wm.submittedLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.dbPath, submittedFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var records []*SubmittedTransaction
+	err = db.Select(q.Eq("Watching", true)).Find(&records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (wm *WalletManager) saveSubmittedTransaction(record *SubmittedTransaction) error {
+	wm.submittedLock.Lock()
+	defer wm.submittedLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.dbPath, submittedFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(record)
+}
+
+//GetSubmittedTransaction 查询通过 submitAndWatch 广播的交易单状态
+func (wm *WalletManager) GetSubmittedTransaction(txid string) (*SubmittedTransaction, error) {
+	wm.submittedLock.Lock()
+	defer wm.submittedLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.dbPath, submittedFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var record SubmittedTransaction
+	err = db.One("TxID", txid, &record)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, errors.New("submitted transaction not found: " + txid)
+		}
+		return nil, err
+	}
+
+	return &record, nil
+}
@@ -0,0 +1,106 @@
+package cennz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
+	"github.com/tidwall/gjson"
+)
+
+func newTestSubmitWalletManager(t *testing.T, backend *testChainBackend) (*WalletManager, func()) {
+	dir, err := ioutil.TempDir("", "cennz-submitted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wm := NewWalletManager()
+	wm.Config.dbPath = dir
+	wm.ApiClient = newTestApiClient(backend, nil)
+	return wm, func() { os.RemoveAll(dir) }
+}
+
+func TestCheckSubmittedTransaction(t *testing.T) {
+	backend := newTestChainBackend()
+	for height := uint64(1); height <= 12; height++ {
+		backend.setBlock(&Block{Height: height, Hash: fmt.Sprintf("0x%064x", height)})
+	}
+
+	wm, cleanup := newTestSubmitWalletManager(t, backend)
+	defer cleanup()
+
+	//交易仍在交易池中
+	signed := "0x2d0284ff"
+	txid, _ := cennzTransaction.GetTransactionHash(signed)
+	backend.pending = []string{signed}
+
+	record := &SubmittedTransaction{TxID: txid, Status: SubmitStatusSubmitted, Watching: true, Height: 10}
+	err := wm.checkSubmittedTransaction(record)
+	if err != nil {
+		t.Fatal("check failed : ", err)
+	}
+	if record.Status != SubmitStatusReady || !record.Watching || record.Checked != 12 {
+		t.Error("wrong pending status : ", record.Status, record.Checked)
+	}
+
+	//重启后从记录中恢复
+	records, err := wm.getWatchingSubmittedTransactions()
+	if err != nil || len(records) != 1 || records[0].TxID != txid || records[0].Checked != 12 {
+		t.Fatal("watching record not reloaded : ", records, err)
+	}
+
+	//打包进已确认区块
+	backend.pending = nil
+	backend.setBlock(&Block{Height: 13, Hash: "0xabcd", Transactions: []Transaction{{TxID: "0x1234"}, {TxID: txid}}})
+	err = wm.checkSubmittedTransaction(records[0])
+	if err != nil {
+		t.Fatal("check failed : ", err)
+	}
+	if records[0].Status != SubmitStatusFinalized || records[0].BlockHash != "0xabcd" || records[0].Watching {
+		t.Error("wrong finalized status : ", records[0].Status, records[0].BlockHash)
+	}
+
+	records, err = wm.getWatchingSubmittedTransactions()
+	if err != nil || len(records) != 0 {
+		t.Error("finished record is still watching : ", records, err)
+	}
+}
+
+func TestWatchSubmittedTransactionTimeout(t *testing.T) {
+	watchTimeout, pollInterval := submitWatchTimeout, submitPollInterval
+	submitWatchTimeout, submitPollInterval = 50*time.Millisecond, 10*time.Millisecond
+	defer func() { submitWatchTimeout, submitPollInterval = watchTimeout, pollInterval }()
+
+	txid, _ := cennzTransaction.GetTransactionHash("0x2d0284ff")
+	backend := newTestChainBackend()
+	for height := uint64(1); height <= 10; height++ {
+		backend.setBlock(&Block{Height: height, Hash: fmt.Sprintf("0x%064x", height)})
+	}
+	backend.setBlock(&Block{Height: 11, Hash: "0xabcd", Transactions: []Transaction{{TxID: txid}}})
+
+	wm, cleanup := newTestSubmitWalletManager(t, backend)
+	defer cleanup()
+
+	//只收到 ready 推送，之后的推送丢失
+	_, sub := newTestWSSubscription()
+	ready := gjson.Parse(`"ready"`)
+	sub.ch <- &ready
+
+	now := time.Now().Unix()
+	record := &SubmittedTransaction{TxID: txid, Status: SubmitStatusSubmitted, Watching: true, Height: 10, CreateAt: now, UpdateAt: now}
+	wm.watchSubmittedTransaction(record, sub)
+
+	//超时后按交易hash轮询到最终状态，并取消订阅
+	if record.Status != SubmitStatusFinalized || record.BlockHash != "0xabcd" || record.Watching {
+		t.Error("wrong status after watching timeout : ", record.Status, record.BlockHash, record.Watching)
+	}
+	if len(record.History) != 2 || record.History[0].Status != SubmitStatusReady {
+		t.Error("wrong status history : ", record.History)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("watch subscription is not closed")
+	}
+}
@@ -31,8 +31,6 @@ import (
 
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/prometheus/common/log"
-
-	"github.com/blocktree/go-owcdrivers/polkadotTransaction"
 )
 
 type TransactionDecoder struct {
@@ -50,6 +48,10 @@ func NewTransactionDecoder(wm *WalletManager) *TransactionDecoder {
 
 //CreateRawTransaction 创建交易单
 func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	//节点未同步完成时余额、nonce 不可信，拒绝创建交易单
+	if err := decoder.wm.ApiClient.checkHealth(); err != nil {
+		return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "node unhealthy: %v", err)
+	}
 	if rawTx.Coin.IsContract {
 		return decoder.CreateCENNZRawTransaction(wrapper, rawTx)
 	}
@@ -81,18 +83,28 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 
 	decoder.wm.Log.Info("nonce : ", nonceUint, " update from : ", from)
 
-	txid, err := decoder.wm.SendRawTransaction(rawTx.RawHex)
+	//广播前先计算txid，节点响应丢失时也能根据txid对账
+	txid, err := cennzTransaction.GetTransactionHash(rawTx.RawHex)
+	if err != nil {
+		return nil, err
+	}
+	rawTx.TxID = txid
+
+	nodeTxid, err := decoder.wm.SendRawTransaction(rawTx.RawHex)
 	if err != nil {
 		decoder.wm.UpdateAddressNonce(wrapper, from, 0)
-		decoder.wm.Log.Error("Error Tx to send: ", rawTx.RawHex)
+		decoder.wm.Log.Error("Error Tx to send: ", rawTx.RawHex, ", txid : ", txid)
 		return nil, err
 	}
 
+	if nodeTxid != txid {
+		decoder.wm.Log.Warning("txid mismatch, local : ", txid, ", node : ", nodeTxid)
+	}
+
 	//交易成功，地址nonce+1并记录到缓存
 	newNonce, _ := math.SafeAdd(nonceUint, uint64(1)) //nonce+1
 	decoder.wm.UpdateAddressNonce(wrapper, from, newNonce)
 
-	rawTx.TxID = txid
 	rawTx.IsSubmit = true
 
 	decimals := int32(4)
@@ -148,11 +160,32 @@ func (decoder *TransactionDecoder) CreateCENNZRawTransaction(wrapper openwallet.
 		return openwallet.NewError(openwallet.ErrCallFullNodeAPIFailed, err.Error())
 	}
 
-	var amountStr, to string
-	for k, v := range rawTx.To {
-		to = k
-		amountStr = v
-		break
+	assetId, err := strconv.ParseUint(contractAddress, 10, 64)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "wrong assetId %s", contractAddress)
+	}
+
+	//多个接收地址时需要的余额为转账总额，手续费按实际的批量转账估算
+	transfers := make([]cennzTransaction.TransferItem, 0, len(rawTx.To))
+	amount := big.NewInt(0)
+	for _, destination := range getSortedDestinations(rawTx.To) {
+		value := common.StringNumToBigIntWithExp(rawTx.To[destination], tokenDecimals)
+		toPub, err := decoder.wm.Decoder.AddressDecode(destination)
+		if err != nil {
+			return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+		}
+		transfers = append(transfers, cennzTransaction.TransferItem{
+			RecipientPubkey: hex.EncodeToString(toPub),
+			Amount:          value,
+			AssetId:         assetId,
+		})
+		amount.Add(amount, value)
+	}
+
+	//估算手续费时包含指定或配置的手续费兑换参数
+	estimateFeeExchange, err := decoder.getEstimateFeeExchange(rawTx)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
 	}
 
 	//地址余额从大到小排序
@@ -174,17 +207,14 @@ func (decoder *TransactionDecoder) CreateCENNZRawTransaction(wrapper openwallet.
 		//检查余额是否超过最低转账
 		addrBalance_BI := common.StringNumToBigIntWithExp(addrBalance.Balance.ConfirmBalance, tokenDecimals)
 
-		amount := common.StringNumToBigIntWithExp(amountStr, tokenDecimals)
-
 		if addrBalance_BI.Cmp(amount) < 0 {
 			errTokenBalance = fmt.Sprintf("the token balance of all addresses is not enough")
 			tokenBalanceNotEnough = true
 			continue
 		}
 		//计算手续费
-		fee, createErr := decoder.wm.GetTransactionFeeEstimated(addrBalance.Balance.Address, to, amount, contractAddress)
+		fee, createErr := decoder.wm.GetTransfersFeeEstimated(addrBalance.Balance.Address, transfers, estimateFeeExchange)
 		if createErr != nil {
-			//decoder.wm.Log.Std.Error("GetTransactionFeeEstimated from[%v] -> to[%v] failed, err=%v", addrBalance.Balance.Address, to, createErr)
 			return createErr
 		}
 
@@ -193,7 +223,19 @@ func (decoder *TransactionDecoder) CreateCENNZRawTransaction(wrapper openwallet.
 			continue
 		}
 
-		if feeBalance.Free.Cmp( fee.Fee ) < 0  {
+		feeExchange, err := decoder.getFeeExchange(rawTx, feeBalance.Free, fee.Fee)
+		if err != nil {
+			return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+		}
+
+		if feeExchange != nil {
+			err = decoder.checkFeeExchangeBalance(addrBalance.Balance.Address, feeExchange, contractAddress, addrBalance_BI, amount)
+			if err != nil {
+				errBalance = err.Error()
+				feeNotEnough = true
+				continue
+			}
+		} else if feeBalance.Free.Cmp( fee.Fee ) < 0  {
 			coinBalanceDec := common.BigIntToDecimals(feeBalance.Balance, int32(decoder.wm.GetFeeToken().Decimals) )
 			errBalance = fmt.Sprintf("the [%s] balance: %s is not enough to call smart contract", decoder.wm.GetFeeToken().Symbol, coinBalanceDec.String())
 			feeNotEnough = true
@@ -251,9 +293,22 @@ func (decoder *TransactionDecoder) SignCennzRawTransaction(wrapper openwallet.Wa
 				return err
 			}
 
+			signatureType := decoder.wm.Config.SignatureType
+
+			//sr25519 的公钥由私钥重新计算，需要与地址记录的公钥一致
+			if signatureType == cennzTransaction.SignatureTypeSr25519 {
+				pubkey, err := cennzTransaction.GetPublicKey(keyBytes, signatureType)
+				if err != nil {
+					return err
+				}
+				if hex.EncodeToString(pubkey) != RemoveOxToAddress(keySignature.Address.PublicKey) {
+					return fmt.Errorf("sr25519 public key of address %s mismatch", keySignature.Address.Address)
+				}
+			}
+
 			//签名交易
 			///////交易单哈希签名
-			signature, err := polkadotTransaction.SignTransaction(keySignature.Message, keyBytes)
+			signature, err := cennzTransaction.SignTransactionWithType(keySignature.Message, keyBytes, signatureType)
 			if err != nil {
 				return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
 			}
@@ -271,6 +326,7 @@ func (decoder *TransactionDecoder) VerifyCENNZRawTransaction(wrapper openwallet.
 	var (
 		emptyTrans = rawTx.RawHex
 		signature  = ""
+		signer     = ""
 	)
 
 	for accountID, keySignatures := range rawTx.Signatures {
@@ -278,6 +334,9 @@ func (decoder *TransactionDecoder) VerifyCENNZRawTransaction(wrapper openwallet.
 		for _, keySignature := range keySignatures {
 
 			signature = keySignature.Signature
+			if keySignature.Address != nil {
+				signer = RemoveOxToAddress(keySignature.Address.PublicKey)
+			}
 
 			log.Debug("Signature:", keySignature.Signature)
 			log.Debug("PublicKey:", keySignature.Address.PublicKey)
@@ -286,18 +345,81 @@ func (decoder *TransactionDecoder) VerifyCENNZRawTransaction(wrapper openwallet.
 
 	signedTrans, pass := cennzTransaction.VerifyAndCombineTransaction(emptyTrans, signature)
 
-	if pass {
-		log.Debug("transaction verify passed")
-		rawTx.IsCompleted = true
-		rawTx.RawHex = signedTrans
-	} else {
+	if !pass {
 		log.Debug("transaction verify failed")
 		rawTx.IsCompleted = false
+		return nil
+	}
+
+	log.Debug("transaction verify passed")
+
+	//按链上的调用序号解析签名后的交易单，只能是转账或由转账组成的批量转账，且与交易单的接收地址和金额一致
+	decoded, err := decoder.verifySignedTransfers(rawTx, signedTrans, signer)
+	if err != nil {
+		rawTx.IsCompleted = false
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "%v", err)
 	}
+	log.Debug("signed transaction:", decoded.ToJSONString())
+
+	rawTx.IsCompleted = true
+	rawTx.RawHex = signedTrans
 
 	return nil
 }
 
+//verifySignedTransfers 解析签名交易单，核对签名地址、调用以及每笔转账的接收地址、资产和金额与 rawTx.To 一致
+func (decoder *TransactionDecoder) verifySignedTransfers(rawTx *openwallet.RawTransaction, signedTrans, signer string) (*cennzTransaction.SignedTransaction, error) {
+	indexes, err := decoder.wm.ApiClient.getRuntimeIndexes("")
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := cennzTransaction.DecodeSignedTransaction(indexes.TransferCall, signedTrans, indexes.BatchCall, indexes.BatchAllCall)
+	if err != nil {
+		return nil, fmt.Errorf("decode signed transaction failed: %v", err)
+	}
+	if !decoded.IsTransfer() {
+		return nil, fmt.Errorf("unsupported call %s, only transfer and batch transfer are allowed", decoded.CallIndex)
+	}
+	if decoded.Signer != signer {
+		return nil, fmt.Errorf("signer %s does not match the signature address", decoded.Signer)
+	}
+
+	transfers := decoded.Transfers
+	if decoded.CallIndex == indexes.TransferCall {
+		transfers = []cennzTransaction.TransferItem{
+			{RecipientPubkey: decoded.RecipientPubkey, Amount: decoded.Amount, AssetId: decoded.AssetId},
+		}
+	}
+
+	destinations := getSortedDestinations(rawTx.To)
+	if len(transfers) != len(destinations) {
+		return nil, fmt.Errorf("signed transaction has %d transfers, expect %d", len(transfers), len(destinations))
+	}
+
+	assetId, err := strconv.ParseUint(rawTx.Coin.Contract.Address, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("wrong assetId %s", rawTx.Coin.Contract.Address)
+	}
+	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
+
+	//批量转账按接收地址排序构建，顺序一致
+	for i, destination := range destinations {
+		toPub, err := decoder.wm.Decoder.AddressDecode(destination)
+		if err != nil {
+			return nil, err
+		}
+		amount := common.StringNumToBigIntWithExp(rawTx.To[destination], tokenDecimals)
+
+		transfer := transfers[i]
+		if transfer.RecipientPubkey != hex.EncodeToString(toPub) || transfer.AssetId != assetId || transfer.Amount.Cmp(amount) != 0 {
+			return nil, fmt.Errorf("transfer %d of signed transaction does not match %s:%s", i, destination, rawTx.To[destination])
+		}
+	}
+
+	return decoded, nil
+}
+
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
 	rate := uint64(decoder.wm.Config.FixedFee)
 	return convertToAmount(rate, 4), "TX", nil
@@ -329,6 +451,9 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 }
 
 func (decoder *TransactionDecoder) CreateTokenSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+	if err := decoder.wm.ApiClient.checkHealth(); err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "node unhealthy: %v", err)
+	}
 
 	var (
 		rawTxArray         = make([]*openwallet.RawTransactionWithError, 0)
@@ -412,7 +537,7 @@ func (decoder *TransactionDecoder) CreateTokenSummaryRawTransaction(wrapper open
 		sumAmount_BI.Sub(addrBalance_BI, retainedBalance)
 
 		////计算手续费
-		feeInfo, createErr := decoder.wm.GetTransactionFeeEstimated(addrBalance.Balance.Address, contractAddress, sumAmount_BI, sumRawTx.Coin.Contract.Address)
+		feeInfo, createErr := decoder.wm.GetTransactionFeeEstimated(addrBalance.Balance.Address, contractAddress, sumAmount_BI, sumRawTx.Coin.Contract.Address, decoder.getConfigFeeExchange())
 		if createErr != nil {
 			//decoder.wm.Log.Std.Error("GetTransactionFeeEstimated from[%v] -> to[%v] failed, err=%v", addrBalance.Balance.Address, sumRawTx.SummaryAddress, createErr)
 			return nil, createErr
@@ -426,8 +551,19 @@ func (decoder *TransactionDecoder) CreateTokenSummaryRawTransaction(wrapper open
 			continue
 		}
 
+		//配置了手续费兑换时，CPAY 不足的地址直接使用其他资产支付手续费，不需要手续费账户补充
+		feeExchange, _ := decoder.getFeeExchange(nil, feeBalance.Free, feeInfo.Fee)
+		if feeExchange != nil && strconv.FormatUint(feeExchange.AssetId, 10) == contractAddress {
+			//兑换资产与汇总资产相同，预留最大兑换数量
+			sumAmount_BI.Sub(sumAmount_BI, feeExchange.MaxPayment)
+			if sumAmount_BI.Sign() <= 0 {
+				continue
+			}
+			sumAmount = common.BigIntToDecimals(sumAmount_BI, tokenDecimals)
+		}
+
 		//判断主币余额是否够手续费
-		if feeBalance.Balance.Cmp(feeInfo.Fee) < 0 {
+		if feeBalance.Balance.Cmp(feeInfo.Fee) < 0 && feeExchange == nil {
 
 			//有手续费账户支持
 			if feesSupportAccount != nil {
@@ -583,6 +719,12 @@ func (decoder *TransactionDecoder) CreateSimpleRawTransaction(wrapper openwallet
 
 	amount := common.StringNumToBigIntWithExp(amountStr, decimals)
 
+	//估算手续费时包含指定或配置的手续费兑换参数
+	estimateFeeExchange, err := decoder.getEstimateFeeExchange(rawTx)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+
 	//地址余额从大到小排序
 	sort.Slice(addrBalanceArray, func(i int, j int) bool {
 		a_amount, _ := decimal.NewFromString(addrBalanceArray[i].Balance.Balance)
@@ -600,7 +742,7 @@ func (decoder *TransactionDecoder) CreateSimpleRawTransaction(wrapper openwallet
 		addrBalance_BI := common.StringNumToBigIntWithExp(addrBalance.Balance.Balance, decimals)
 
 		////计算手续费
-		feeInfo, err = decoder.wm.GetTransactionFeeEstimated(addrBalance.Balance.Address, to, amount, rawTx.Coin.Contract.Address)
+		feeInfo, err = decoder.wm.GetTransactionFeeEstimated(addrBalance.Balance.Address, to, amount, rawTx.Coin.Contract.Address, estimateFeeExchange)
 		if err != nil {
 			//decoder.wm.Log.Std.Error("GetTransactionFeeEstimated from[%v] -> to[%v] failed, err=%v", addrBalance.Address, to, err)
 			continue
@@ -658,37 +800,59 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 		accountTotalSent = decimal.Zero
 		txFrom           = make([]string, 0)
 		txTo             = make([]string, 0)
-		amountStr        string
-		destination      string
+		transfers        = make([]cennzTransaction.TransferItem, 0)
+		totalAmount      = big.NewInt(0)
 	)
 
 	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
 	feeDecimals := int32(decoder.wm.GetFeeToken().Decimals)
 
-	for k, v := range rawTx.To {
-		destination = k
-		amountStr = v
-		break
+	assetId, err := strconv.ParseUint(rawTx.Coin.Contract.Address, 10, 64)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "wrong assetId %s", rawTx.Coin.Contract.Address)
 	}
 
-	//计算账户的实际转账amount
-	accountTotalSentAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", destination)
-	if findErr != nil || len(accountTotalSentAddresses) == 0 {
-		amountDec, _ := decimal.NewFromString(amountStr)
-		accountTotalSent = accountTotalSent.Add(amountDec)
+	//多个接收地址时打包为一笔 utility.batch 交易
+	for _, destination := range getSortedDestinations(rawTx.To) {
+		amountStr := rawTx.To[destination]
+		amount := common.StringNumToBigIntWithExp(amountStr, tokenDecimals)
+		if amount.Sign() <= 0 {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid amount %s to %s", amountStr, destination)
+		}
+
+		toPub, err := decoder.wm.Decoder.AddressDecode(destination)
+		if err != nil {
+			return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+		}
+
+		transfers = append(transfers, cennzTransaction.TransferItem{
+			RecipientPubkey: hex.EncodeToString(toPub),
+			Amount:          amount,
+			AssetId:         assetId,
+		})
+		totalAmount.Add(totalAmount, amount)
+
+		//计算账户的实际转账amount
+		accountTotalSentAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", destination)
+		if findErr != nil || len(accountTotalSentAddresses) == 0 {
+			amountDec, _ := decimal.NewFromString(amountStr)
+			accountTotalSent = accountTotalSent.Add(amountDec)
+		}
+
+		txTo = append(txTo, fmt.Sprintf("%s:%s", destination, amountStr))
 	}
 
-	txFrom = []string{fmt.Sprintf("%s:%s", addrBalance.Address, amountStr)}
-	txTo = []string{fmt.Sprintf("%s:%s", destination, amountStr)}
+	if len(transfers) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "receiver addresses is empty")
+	}
 
-	totalFeeDecimal := common.BigIntToDecimals(feeInfo.Fee, feeDecimals )
+	totalAmountStr := common.BigIntToDecimals(totalAmount, tokenDecimals).String()
+	txFrom = []string{fmt.Sprintf("%s:%s", addrBalance.Address, totalAmountStr)}
 
 	feesDec, _ := decimal.NewFromString(rawTx.Fees)
 	accountTotalSent = accountTotalSent.Add(feesDec)
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
-	rawTx.FeeRate = strconv.FormatUint(feeInfo.Fee.Uint64(), 10)
-	rawTx.Fees = totalFeeDecimal.String()
 	//rawTx.ExtParam = string(extparastr)
 	rawTx.TxAmount = accountTotalSent.String()
 	rawTx.TxFrom = txFrom
@@ -721,18 +885,55 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 	}
 
 	//构建合约交易
-	amount := common.StringNumToBigIntWithExp(amountStr, tokenDecimals)
-	if addrBalance.Balance.Cmp(amount) < 0 {
-		return openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "the token balance: %s is not enough", amountStr)
+	if addrBalance.Balance.Cmp(totalAmount) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "the token balance: %s is not enough", totalAmountStr)
 		//return openwallet.Errorf("the token balance: %s is not enough", amountStr)
 	}
 
-	if addrBalance.FeeBalance.Free.Cmp( feeInfo.Fee ) < 0 {
-		coinBalance := common.BigIntToDecimals(addrBalance.Balance, decoder.wm.Decimal())
-		return openwallet.Errorf(openwallet.ErrInsufficientFees, "the [%s] balance: %s is not enough to call smart contract", rawTx.Coin.Symbol, coinBalance)
-		//return openwallet.Errorf("the [%s] balance: %s is not enough to call smart contract", rawTx.Coin.Symbol, coinBalance)
+	finalizedBlock, err := decoder.wm.ApiClient.getFinalizedBlock()
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
 	}
 
+	//先按估算的手续费决定是否兑换手续费，再按实际构建的交易单查询手续费
+	feeExchange, err := decoder.getFeeExchange(rawTx, addrBalance.FeeBalance.Free, feeInfo.Fee)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+
+	tx, fee, err := decoder.newTransferTxStructWithFee(addr.PublicKey, transfers, nonce, feeExchange, finalizedBlock, feeInfo.Fee)
+	if err != nil {
+		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+
+	//实际手续费超过 CPAY 余额时改为兑换手续费
+	if feeExchange == nil && addrBalance.FeeBalance.Free.Cmp(fee) < 0 {
+		feeExchange, err = decoder.getFeeExchange(rawTx, addrBalance.FeeBalance.Free, fee)
+		if err != nil {
+			return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+		}
+		if feeExchange != nil {
+			tx, fee, err = decoder.newTransferTxStructWithFee(addr.PublicKey, transfers, nonce, feeExchange, finalizedBlock, fee)
+			if err != nil {
+				return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
+			}
+		}
+	}
+
+	if feeExchange != nil {
+		err = decoder.checkFeeExchangeBalance(addrBalance.Address, feeExchange, rawTx.Coin.Contract.Address, addrBalance.Balance, totalAmount)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrInsufficientFees, err.Error())
+		}
+	} else if addrBalance.FeeBalance.Free.Cmp( fee ) < 0 {
+		coinBalance := common.BigIntToDecimals(addrBalance.FeeBalance.Free, feeDecimals)
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "the [%s] balance: %s is not enough to pay fee %s", decoder.wm.GetFeeToken().Symbol, coinBalance, common.BigIntToDecimals(fee, feeDecimals))
+	}
+
+	feeInfo.Fee = fee
+	rawTx.FeeRate = fee.String()
+	rawTx.Fees = common.BigIntToDecimals(fee, feeDecimals).String()
+
 	nonceJSON := map[string]interface{}{}
 	if len(rawTx.ExtParam) > 0 {
 		err = json.Unmarshal([]byte(rawTx.ExtParam), &nonceJSON)
@@ -744,17 +945,7 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 
 	rawTx.SetExtParam("nonce", nonceJSON)
 
-	mostHeightBlock, err := decoder.wm.ApiClient.getMostHeightBlock()
-	if err != nil {
-		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
-	}
-
-	toPub, err := decoder.wm.Decoder.AddressDecode(destination)
-	if err != nil {
-		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
-	}
-
-	emptyTrans, hash, err := decoder.CreateEmptyRawTransactionAndMessage(addr.PublicKey, hex.EncodeToString(toPub), amount.Uint64(), nonce, feeInfo.Fee.Uint64(), mostHeightBlock, rawTx.Coin.Contract.Address)
+	emptyTrans, hash, err := tx.CreateEmptyTransactionAndMessage()
 
 	if err != nil {
 		return openwallet.NewError(openwallet.ErrCreateRawTransactionFailed, err.Error())
@@ -778,13 +969,90 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 
 	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 
-	rawTx.FeeRate = strconv.FormatUint(feeInfo.Fee.Uint64(), 10)
-
 	rawTx.IsBuilt = true
 
 	return nil
 }
 
+//getFeeExchange 获取手续费兑换参数，优先使用交易单扩展字段 feeExchange，
+//没有指定时，如果 CPAY 不足以支付手续费则使用配置的兑换资产，返回 nil 表示使用 CPAY 支付
+func (decoder *TransactionDecoder) getFeeExchange(rawTx *openwallet.RawTransaction, feeBalance *big.Int, fee *big.Int) (*cennzTransaction.FeeExchange, error) {
+	if rawTx != nil && rawTx.GetExtParam().Get("feeExchange").Exists() {
+		return decoder.getExtFeeExchange(rawTx)
+	}
+
+	if feeBalance.Cmp(fee) >= 0 {
+		return nil, nil
+	}
+
+	return decoder.getConfigFeeExchange(), nil
+}
+
+//getEstimateFeeExchange 获取估算手续费时使用的兑换参数，指定或配置了兑换资产时按兑换交易估算，
+//兑换交易更长，估算的手续费不会低于实际构建的交易
+func (decoder *TransactionDecoder) getEstimateFeeExchange(rawTx *openwallet.RawTransaction) (*cennzTransaction.FeeExchange, error) {
+	if rawTx != nil && rawTx.GetExtParam().Get("feeExchange").Exists() {
+		return decoder.getExtFeeExchange(rawTx)
+	}
+	return decoder.getConfigFeeExchange(), nil
+}
+
+//getExtFeeExchange 解析交易单扩展字段 feeExchange
+func (decoder *TransactionDecoder) getExtFeeExchange(rawTx *openwallet.RawTransaction) (*cennzTransaction.FeeExchange, error) {
+	ext := rawTx.GetExtParam().Get("feeExchange")
+	maxPayment, err := parseBigIntAmount(ext.Get("maxPayment").String())
+	if err != nil || maxPayment.Sign() <= 0 || !ext.Get("assetId").Exists() {
+		return nil, fmt.Errorf("invalid fee exchange: %s", ext.Raw)
+	}
+	return &cennzTransaction.FeeExchange{
+		AssetId:    ext.Get("assetId").Uint(),
+		MaxPayment: maxPayment,
+	}, nil
+}
+
+//getConfigFeeExchange 获取配置的兑换参数，没有配置时返回 nil
+func (decoder *TransactionDecoder) getConfigFeeExchange() *cennzTransaction.FeeExchange {
+	if decoder.wm.Config.FeeExchangeAssetId == 0 {
+		return nil
+	}
+	return &cennzTransaction.FeeExchange{
+		AssetId:    decoder.wm.Config.FeeExchangeAssetId,
+		MaxPayment: new(big.Int).Set(decoder.wm.Config.FeeExchangeMaxPayment),
+	}
+}
+
+//checkFeeExchangeBalance 检查地址是否有足够的兑换资产，兑换资产与转账资产相同时需要同时扣除转账金额
+func (decoder *TransactionDecoder) checkFeeExchangeBalance(address string, feeExchange *cennzTransaction.FeeExchange, assetId string, tokenBalance *big.Int, amount *big.Int) error {
+	feeAssetId := strconv.FormatUint(feeExchange.AssetId, 10)
+
+	if feeAssetId == assetId {
+		total := new(big.Int).Add(amount, feeExchange.MaxPayment)
+		if tokenBalance.Cmp(total) < 0 {
+			return fmt.Errorf("the asset [%s] balance: %s is not enough to pay %s and exchange fee %s", assetId, tokenBalance.String(), amount.String(), feeExchange.MaxPayment.String())
+		}
+		return nil
+	}
+
+	balance, err := decoder.wm.ApiClient.getBalance(address, feeAssetId)
+	if err != nil {
+		return err
+	}
+	if balance.Free.Cmp(feeExchange.MaxPayment) < 0 {
+		return fmt.Errorf("the asset [%s] balance: %s is not enough to exchange fee %s", feeAssetId, balance.Free.String(), feeExchange.MaxPayment.String())
+	}
+	return nil
+}
+
+//getSortedDestinations 接收地址排序，保证批量转账中的顺序固定
+func getSortedDestinations(to map[string]string) []string {
+	destinations := make([]string, 0, len(to))
+	for destination := range to {
+		destinations = append(destinations, destination)
+	}
+	sort.Strings(destinations)
+	return destinations
+}
+
 //CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 	if sumRawTx.Coin.IsContract {
@@ -793,50 +1061,110 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper
 	return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "[%s] Miss contract details to create transaction!", sumRawTx.Account.AccountID)
 }
 
-func (decoder *TransactionDecoder) CreateEmptyRawTransactionAndMessage(fromPub string, toPub string, amount uint64, nonce uint64, fee uint64, mostHeightBlock *Block, assetIdStr string) (string, string, error) {
+func (decoder *TransactionDecoder) CreateEmptyRawTransactionAndMessage(fromPub string, transfers []cennzTransaction.TransferItem, nonce uint64, feeExchange *cennzTransaction.FeeExchange, mostHeightBlock *Block) (string, string, error) {
+	tx, err := decoder.newTransferTxStruct(fromPub, transfers, nonce, feeExchange, mostHeightBlock)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tx.CreateEmptyTransactionAndMessage()
+}
+
+//newTransferTxStructWithFee 构建交易单并按该交易单查询手续费，节点查询失败时使用 estimated
+func (decoder *TransactionDecoder) newTransferTxStructWithFee(fromPub string, transfers []cennzTransaction.TransferItem, nonce uint64, feeExchange *cennzTransaction.FeeExchange, mostHeightBlock *Block, estimated *big.Int) (*cennzTransaction.TxStruct, *big.Int, error) {
+	tx, err := decoder.newTransferTxStruct(fromPub, transfers, nonce, feeExchange, mostHeightBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fee, err := decoder.wm.queryTransactionFee(tx)
+	if err != nil {
+		decoder.wm.Log.Warning("query transaction fee failed, use estimated fee ", estimated.String(), ", error : ", err)
+		return tx, new(big.Int).Set(estimated), nil
+	}
+
+	return tx, fee, nil
+}
+
+//newTransferTxStruct 构建转账交易，多笔转账时使用 utility.batch
+func (decoder *TransactionDecoder) newTransferTxStruct(fromPub string, transfers []cennzTransaction.TransferItem, nonce uint64, feeExchange *cennzTransaction.FeeExchange, mostHeightBlock *Block) (*cennzTransaction.TxStruct, error) {
+
+	if len(transfers) == 0 {
+		return nil, errors.New("no transfer to create")
+	}
 
-	runtimeVersion, err := decoder.wm.ApiClient.getRuntimeVersion()
+	//调用序号与版本号取自同一个运行时
+	runtimeIndexes, err := decoder.wm.ApiClient.getRuntimeIndexes("")
 	if err!=nil {
-		return "", "", err
+		return nil, err
 	}
 	genesisHash, err := decoder.wm.ApiClient.getGenesisBlockHash()
 	if err!=nil {
-		return "", "", err
+		return nil, err
 	}
-	specVersion := runtimeVersion.SpecVersion
-	txVersion := runtimeVersion.TransactionVersion
+	specVersion := runtimeIndexes.SpecVersion
+	txVersion := runtimeIndexes.TransactionVersion
 
-	assetId, err := strconv.ParseUint(assetIdStr, 10, 64)
-	if err!=nil {
-		return "", "", errors.New("wrong assetId "+assetIdStr)
+	//多笔转账使用 utility.batch
+	batchCallIndex := ""
+	if len(transfers) > 1 {
+		batchCallIndex, err = runtimeIndexes.GetBatchCall(decoder.wm.Config.BatchCall)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//mortal era 需要使用起始高度的区块哈希签名，永久有效的交易使用创世块哈希
+	period := decoder.wm.Config.EraPeriod
+	checkpointHash := genesisHash
+	if period > 0 {
+		birth := cennzTransaction.GetEraBirth(mostHeightBlock.Height, period)
+		checkpointHash = mostHeightBlock.Hash
+		if birth != mostHeightBlock.Height || len(checkpointHash) == 0 {
+			checkpointHash, err = decoder.wm.ApiClient.getBlockHash(birth)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	tx := cennzTransaction.TxStruct{
 		//发送方公钥
 		SenderPubkey: fromPub,
 		//接收方公钥
-		RecipientPubkey: toPub,
+		RecipientPubkey: transfers[0].RecipientPubkey,
 		//发送金额（最小单位）
-		Amount: amount,
+		Amount: transfers[0].Amount,
 		//资产id
-		AssetId: assetId,
+		AssetId: transfers[0].AssetId,
 		//nonce
 		Nonce: nonce,
-		//手续费（最小单位）
-		Fee: 0,
+		//手续费兑换，为空时使用 CPAY 支付
+		FeeExchange: feeExchange,
 		//tip
 		Tip: 0,
 		//当前高度
 		BlockHeight: mostHeightBlock.Height,
-		//当前高度区块哈希
-		BlockHash: RemoveOxToAddress(genesisHash),
+		//有效期
+		Period: period,
+		//era起始高度区块哈希
+		BlockHash: RemoveOxToAddress(checkpointHash),
 		//创世块哈希
 		GenesisHash: RemoveOxToAddress(genesisHash),
 		//spec版本
 		SpecVersion: specVersion,
 		//TransactionVersion
 		TxVersion : txVersion,
+		//签名类型
+		SignatureType: decoder.wm.Config.SignatureType,
+		//转账调用序号
+		CallIndex: runtimeIndexes.TransferCall,
 	}
 
-	return tx.CreateEmptyTransactionAndMessage()
+	if len(batchCallIndex) > 0 {
+		tx.Transfers = transfers
+		tx.BatchCallIndex = batchCallIndex
+	}
+
+	return &tx, nil
 }
@@ -0,0 +1,360 @@
+package cennz
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/cennz-adapter/cennzTransaction"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+type testHDKeyWallet struct {
+	openwallet.WalletDAIBase
+	key *hdkeystore.HDKey
+}
+
+func (w *testHDKeyWallet) HDKey(password ...string) (*hdkeystore.HDKey, error) {
+	return w.key, nil
+}
+
+func TestSignCennzRawTransaction_Sr25519(t *testing.T) {
+	wm := NewWalletManager()
+	wm.Config.SignatureType = cennzTransaction.SignatureTypeSr25519
+	wm.ApiClient = newTestApiClient(newTestChainBackend(), &RuntimeIndexes{
+		SpecVersion:  37,
+		TransferCall: "0401",
+		BatchCall:    "1a00",
+	})
+
+	seed, _ := hex.DecodeString("d1c1a1a4e1f2f3a0b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b")
+	key, err := hdkeystore.NewHDKey(seed, "sr25519", "m/44'/88'")
+	if err != nil {
+		t.Fatal("create hd key failed : ", err)
+	}
+
+	//账户只保存公钥，地址由公钥派生
+	accountKey, err := key.DerivedKeyWithPath("m/44'/88'/0'", owcrypt.ECC_CURVE_ED25519)
+	if err != nil {
+		t.Fatal("derive account key failed : ", err)
+	}
+	account := &openwallet.AssetsAccount{
+		AccountID: "sr25519",
+		Symbol:    Symbol,
+		HDPath:    "m/44'/88'/0'",
+		OwnerKeys: []string{accountKey.GetPublicKey().OWEncode()},
+	}
+
+	decoder := NewAddressDecoderV2(wm)
+	if !decoder.SupportCustomCreateAddressFunction() {
+		t.Fatal("sr25519 address should be created by CustomCreateAddress")
+	}
+	address, err := decoder.CustomCreateAddress(account, 1)
+	if err != nil {
+		t.Fatal("create address failed : ", err)
+	}
+
+	pubkey, _ := decoder.AddressDecode(address.Address)
+	if hex.EncodeToString(pubkey) != address.PublicKey {
+		t.Fatal("address does not match public key")
+	}
+
+	tx := cennzTransaction.TxStruct{
+		SenderPubkey:    address.PublicKey,
+		RecipientPubkey: "7dd904f18b1e42c7f2b62429245771f51749a42be06c02bd4251df69f2c141df",
+		Amount:          big.NewInt(1000),
+		AssetId:         1,
+		Nonce:           1,
+		BlockHeight:     4571393,
+		Period:          64,
+		BlockHash:       "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
+		GenesisHash:     "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
+		SpecVersion:     37,
+		TxVersion:       5,
+		SignatureType:   cennzTransaction.SignatureTypeSr25519,
+	}
+	emptyTrans, message, err := tx.CreateEmptyTransactionAndMessage()
+	if err != nil {
+		t.Fatal("create transaction failed : ", err)
+	}
+
+	recipientPub, _ := hex.DecodeString(tx.RecipientPubkey)
+	recipient, _ := decoder.AddressEncode(recipientPub)
+
+	rawTx := &openwallet.RawTransaction{
+		RawHex:  emptyTrans,
+		Coin:    openwallet.Coin{Contract: openwallet.SmartContract{Address: "1", Decimals: 4}},
+		To:      map[string]string{recipient: "0.1"},
+		Account: &openwallet.AssetsAccount{AccountID: account.AccountID},
+		Signatures: map[string][]*openwallet.KeySignature{
+			account.AccountID: {
+				{
+					EccType: owcrypt.ECC_CURVE_ED25519,
+					Address: address,
+					Message: message,
+				},
+			},
+		},
+	}
+
+	txDecoder := NewTransactionDecoder(wm)
+	err = txDecoder.SignCennzRawTransaction(&testHDKeyWallet{key: key}, rawTx)
+	if err != nil {
+		t.Fatal("sign failed : ", err)
+	}
+
+	msg, _ := hex.DecodeString(message)
+	signature, _ := hex.DecodeString(rawTx.Signatures[account.AccountID][0].Signature)
+	if !cennzTransaction.VerifySignature(pubkey, msg, signature, cennzTransaction.SignatureTypeSr25519) {
+		t.Fatal("signature verify failed")
+	}
+
+	//签名交易单的金额与接收地址必须与 To 一致
+	tampered := *rawTx
+	tampered.To = map[string]string{recipient: "0.2"}
+	err = txDecoder.VerifyCENNZRawTransaction(&testHDKeyWallet{key: key}, &tampered)
+	if err == nil || tampered.IsCompleted {
+		t.Fatal("raw transaction with wrong amount should not pass")
+	}
+	tampered.To = map[string]string{address.Address: "0.1"}
+	err = txDecoder.VerifyCENNZRawTransaction(&testHDKeyWallet{key: key}, &tampered)
+	if err == nil || tampered.IsCompleted {
+		t.Fatal("raw transaction with wrong recipient should not pass")
+	}
+
+	err = txDecoder.VerifyCENNZRawTransaction(&testHDKeyWallet{key: key}, rawTx)
+	if err != nil || !rawTx.IsCompleted {
+		t.Fatal("raw transaction verify failed : ", err)
+	}
+}
+
+func TestNewTransferTxStructWithFee(t *testing.T) {
+	backend := newTestChainBackend()
+	backend.fee = big.NewInt(1000)
+	for height := uint64(1); height <= 100; height++ {
+		backend.setBlock(&Block{Height: height, Hash: fmt.Sprintf("0x%064x", height)})
+	}
+
+	wm := NewWalletManager()
+	wm.Config.BatchCall = BatchCallBatchAll
+	wm.ApiClient = newTestApiClient(backend, &RuntimeIndexes{
+		SpecVersion:        backend.specVersion,
+		TransactionVersion: 5,
+		TransferCall:       "0401",
+		BatchAllCall:       "1a02",
+	})
+	decoder := NewTransactionDecoder(wm)
+
+	transfers := []cennzTransaction.TransferItem{
+		{RecipientPubkey: "7dd904f18b1e42c7f2b62429245771f51749a42be06c02bd4251df69f2c141df", Amount: big.NewInt(1000), AssetId: 16000},
+		{RecipientPubkey: "86377c388ec1afc558ef40c5edb3b4f7bba1a697b1bb711ece23fc7cdbfe2e1f", Amount: big.NewInt(2000), AssetId: 16000},
+	}
+	feeExchange := &cennzTransaction.FeeExchange{AssetId: 16000, MaxPayment: big.NewInt(50000)}
+	finalized, _ := backend.GetFinalizedBlock()
+
+	tx, fee, err := decoder.newTransferTxStructWithFee("ec17fb0bc229cbf6c157632a7a25490dc85e1e9bd2398a00bf619c254429c266", transfers, 3, feeExchange, finalized, big.NewInt(1))
+	if err != nil {
+		t.Fatal("create transaction failed : ", err)
+	}
+
+	//手续费加上 FeeMargin
+	if fee.Int64() != 1200 {
+		t.Error("wrong fee : ", fee.String())
+	}
+
+	//查询手续费的交易单与创建的交易单一致，包含批量转账和手续费兑换
+	if len(backend.feeQueries) != 1 {
+		t.Fatal("wrong fee queries : ", len(backend.feeQueries))
+	}
+	st, err := cennzTransaction.DecodeSignedTransaction("0401", backend.feeQueries[0], "1a02")
+	if err != nil {
+		t.Fatal("decode fee query transaction failed : ", err)
+	}
+	if st.CallIndex != "1a02" || len(st.Transfers) != 2 || st.FeeExchange == nil || st.FeeExchange.AssetId != 16000 {
+		t.Error("fee is not queried with the built transaction : ", st.ToJSONString())
+	}
+	if st.Nonce != 3 || st.Era != hex.EncodeToString(cennzTransaction.GetEra(tx.BlockHeight, tx.Period)) {
+		t.Error("wrong nonce or era of fee query transaction : ", st.ToJSONString())
+	}
+
+	//节点查询失败时使用估算值
+	backend.fee = nil
+	_, fee, err = decoder.newTransferTxStructWithFee("ec17fb0bc229cbf6c157632a7a25490dc85e1e9bd2398a00bf619c254429c266", transfers, 3, nil, finalized, big.NewInt(5))
+	if err != nil || fee.Int64() != 5 {
+		t.Error("estimated fee is not used : ", fee, err)
+	}
+}
+
+func TestGetTransfersFeeEstimated(t *testing.T) {
+	backend := newTestChainBackend()
+	backend.fee = big.NewInt(1000)
+
+	wm := NewWalletManager()
+	wm.Config.BatchCall = BatchCallBatch
+	wm.ApiClient = newTestApiClient(backend, &RuntimeIndexes{
+		SpecVersion:  backend.specVersion,
+		TransferCall: "0401",
+		BatchCall:    "1a00",
+		BatchAllCall: "1a02",
+	})
+
+	fromPub, _ := hex.DecodeString("ec17fb0bc229cbf6c157632a7a25490dc85e1e9bd2398a00bf619c254429c266")
+	from, _ := wm.Decoder.AddressEncode(fromPub)
+	transfers := []cennzTransaction.TransferItem{
+		{RecipientPubkey: "7dd904f18b1e42c7f2b62429245771f51749a42be06c02bd4251df69f2c141df", Amount: big.NewInt(1000), AssetId: 1},
+		{RecipientPubkey: "86377c388ec1afc558ef40c5edb3b4f7bba1a697b1bb711ece23fc7cdbfe2e1f", Amount: big.NewInt(2000), AssetId: 1},
+	}
+
+	feeInfo, err := wm.GetTransfersFeeEstimated(from, transfers, nil)
+	if err != nil || feeInfo.Fee.Int64() != 1200 {
+		t.Fatal("wrong estimated fee : ", feeInfo, err)
+	}
+
+	//多个接收地址时按批量转账交易估算，包含全部转账
+	if len(backend.feeQueries) != 1 {
+		t.Fatal("wrong fee queries : ", len(backend.feeQueries))
+	}
+	st, err := cennzTransaction.DecodeSignedTransaction("0401", backend.feeQueries[0], "1a00")
+	if err != nil {
+		t.Fatal("decode fee query transaction failed : ", err)
+	}
+	if st.CallIndex != "1a00" || len(st.Transfers) != 2 || st.Transfers[1].Amount.Int64() != 2000 {
+		t.Error("fee is not estimated with the batch transaction : ", st.ToJSONString())
+	}
+
+	//单个接收地址时为普通转账
+	_, err = wm.GetTransfersFeeEstimated(from, transfers[:1], nil)
+	if err != nil {
+		t.Fatal("estimate fee failed : ", err)
+	}
+	st, err = cennzTransaction.DecodeSignedTransaction("0401", backend.feeQueries[1], "1a00")
+	if err != nil || st.CallIndex != "0401" || st.RecipientPubkey != transfers[0].RecipientPubkey {
+		t.Error("fee is not estimated with the transfer transaction : ", err)
+	}
+
+	//配置了手续费兑换时按兑换交易估算
+	wm.Config.FeeExchangeAssetId = 16000
+	wm.Config.FeeExchangeMaxPayment = big.NewInt(50000)
+	to, _ := wm.Decoder.AddressEncode(fromPub)
+	_, err = wm.GetTransactionFeeEstimated(from, to, big.NewInt(1000), "1", NewTransactionDecoder(wm).getConfigFeeExchange())
+	if err != nil {
+		t.Fatal("estimate fee failed : ", err)
+	}
+	st, err = cennzTransaction.DecodeSignedTransaction("0401", backend.feeQueries[2], "1a00")
+	if err != nil || st.FeeExchange == nil || st.FeeExchange.AssetId != 16000 || st.FeeExchange.MaxPayment.Int64() != 50000 {
+		t.Error("fee is not estimated with the fee exchange : ", err)
+	}
+
+	//节点查询失败时使用固定手续费
+	backend.fee = nil
+	wm.Config.FixedFee = 15000
+	feeInfo, err = wm.GetTransfersFeeEstimated(from, transfers, nil)
+	if err != nil || feeInfo.Fee.Int64() != 15000 {
+		t.Error("fixed fee is not used : ", feeInfo, err)
+	}
+}
+
+func TestVerifyCENNZRawTransactionBatch(t *testing.T) {
+	wm := NewWalletManager()
+	wm.ApiClient = newTestApiClient(newTestChainBackend(), &RuntimeIndexes{
+		SpecVersion:  37,
+		TransferCall: "0401",
+		BatchCall:    "1a00",
+	})
+	decoder := NewAddressDecoderV2(wm)
+
+	seed, _ := hex.DecodeString("d1c1a1a4e1f2f3a0b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b")
+	key, err := hdkeystore.NewHDKey(seed, "batch", "m/44'/88'")
+	if err != nil {
+		t.Fatal("create hd key failed : ", err)
+	}
+	childKey, err := key.DerivedKeyWithPath("m/44'/88'/0'/0/1", owcrypt.ECC_CURVE_ED25519)
+	if err != nil {
+		t.Fatal("derive key failed : ", err)
+	}
+	prikey, _ := childKey.GetPrivateKeyBytes()
+	pubkey := childKey.GetPublicKeyBytes()
+
+	//批量转账按接收地址排序
+	to := map[string]string{}
+	transfers := make([]cennzTransaction.TransferItem, 0)
+	recipients := map[string]string{}
+	for _, pub := range []string{"7dd904f18b1e42c7f2b62429245771f51749a42be06c02bd4251df69f2c141df", "86377c388ec1afc558ef40c5edb3b4f7bba1a697b1bb711ece23fc7cdbfe2e1f"} {
+		pubBytes, _ := hex.DecodeString(pub)
+		address, _ := decoder.AddressEncode(pubBytes)
+		recipients[address] = pub
+	}
+	for i, address := range getSortedDestinations(recipients) {
+		amount := int64(1000 * (i + 1))
+		to[address] = common.BigIntToDecimals(big.NewInt(amount), 4).String()
+		transfers = append(transfers, cennzTransaction.TransferItem{RecipientPubkey: recipients[address], Amount: big.NewInt(amount), AssetId: 1})
+	}
+
+	tx := cennzTransaction.TxStruct{
+		SenderPubkey:   hex.EncodeToString(pubkey),
+		Nonce:          1,
+		BlockHeight:    4571393,
+		Period:         64,
+		BlockHash:      "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
+		GenesisHash:    "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
+		SpecVersion:    37,
+		TxVersion:      5,
+		SignatureType:  cennzTransaction.SignatureTypeEd25519,
+		CallIndex:      "0401",
+		Transfers:      transfers,
+		BatchCallIndex: "1a00",
+	}
+	emptyTrans, message, err := tx.CreateEmptyTransactionAndMessage()
+	if err != nil {
+		t.Fatal("create transaction failed : ", err)
+	}
+	signature, err := cennzTransaction.SignTransactionWithType(message, prikey, cennzTransaction.SignatureTypeEd25519)
+	if err != nil {
+		t.Fatal("sign failed : ", err)
+	}
+
+	newRawTx := func(to map[string]string) *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			RawHex: emptyTrans,
+			Coin:   openwallet.Coin{Contract: openwallet.SmartContract{Address: "1", Decimals: 4}},
+			To:     to,
+			Signatures: map[string][]*openwallet.KeySignature{
+				"batch": {
+					{
+						Address:   &openwallet.Address{PublicKey: hex.EncodeToString(pubkey)},
+						Message:   message,
+						Signature: hex.EncodeToString(signature),
+					},
+				},
+			},
+		}
+	}
+
+	txDecoder := NewTransactionDecoder(wm)
+
+	rawTx := newRawTx(to)
+	err = txDecoder.VerifyCENNZRawTransaction(nil, rawTx)
+	if err != nil || !rawTx.IsCompleted {
+		t.Fatal("batch transaction verify failed : ", err)
+	}
+
+	//接收地址之间的金额互换
+	swapped := map[string]string{}
+	destinations := getSortedDestinations(to)
+	swapped[destinations[0]] = to[destinations[1]]
+	swapped[destinations[1]] = to[destinations[0]]
+	rawTx = newRawTx(swapped)
+	if err = txDecoder.VerifyCENNZRawTransaction(nil, rawTx); err == nil || rawTx.IsCompleted {
+		t.Error("batch transaction with swapped amounts should not pass")
+	}
+
+	//缺少一个接收地址
+	rawTx = newRawTx(map[string]string{destinations[0]: to[destinations[0]]})
+	if err = txDecoder.VerifyCENNZRawTransaction(nil, rawTx); err == nil || rawTx.IsCompleted {
+		t.Error("batch transaction with missing recipient should not pass")
+	}
+}
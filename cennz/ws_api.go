@@ -0,0 +1,529 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package cennz
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	//请求等待响应的超时时间
+	wsRequestTimeout = 30 * time.Second
+	//重连等待时间，每次失败后翻倍
+	wsReconnectMinWait = time.Second
+	wsReconnectMaxWait = 30 * time.Second
+	//订阅推送的缓冲数量
+	wsSubscriptionBuffer = 64
+	//缓冲已满时等待消费的时间，超时后关闭订阅，由使用方重新查询
+	wsSubscriptionSendTimeout = 10 * time.Second
+)
+
+//发起时有副作用的订阅，如广播交易，断线后不重新订阅，直接关闭由使用方改为轮询
+var wsOneShotSubscriptions = map[string]bool{
+	"author_submitAndWatchExtrinsic": true,
+}
+
+var (
+	errWSClosed       = errors.New("websocket client closed")
+	errWSDisconnected = errors.New("websocket connection lost")
+)
+
+// WSClient 基于 websocket 的 JSON-RPC 客户端，所有请求复用一条长连接，断线后自动重连并恢复订阅
+type WSClient struct {
+	BaseURL string
+	Debug   bool
+
+	nextID uint64
+
+	connLock sync.Mutex
+	conn     *websocket.Conn
+	//连接建立后关闭，断线后重新创建
+	ready chan struct{}
+
+	//websocket 不支持并发写
+	writeLock sync.Mutex
+
+	pendingLock sync.Mutex
+	pending     map[uint64]*wsRequest
+
+	subsLock      sync.Mutex
+	subscriptions map[string]*Subscription // key = 订阅id
+	//所有订阅，重连后按此列表重新订阅，一次性订阅在断线时关闭
+	allSubs map[*Subscription]struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type wsRequest struct {
+	response chan *gjson.Result
+	//不为空时表示订阅请求，收到响应时登记订阅id
+	sub *Subscription
+}
+
+// Subscription 节点推送的订阅，推送的内容从 C 读取，客户端关闭或取消订阅后 C 被关闭
+type Subscription struct {
+	C <-chan *gjson.Result
+
+	ch                chan *gjson.Result
+	client            *WSClient
+	method            string
+	unsubscribeMethod string
+	params            []interface{}
+
+	//当前连接上的订阅id和是否已取消，由 WSClient.subsLock 保护
+	id        string
+	closed    bool
+	closeOnce sync.Once
+
+	//关闭 ch 前先关闭 quit，等待中的推送立即返回，sendLock 保证不会向已关闭的 ch 写入
+	quit     chan struct{}
+	sendLock sync.Mutex
+}
+
+func NewWSClient(url string, debug bool) *WSClient {
+	c := WSClient{
+		BaseURL:       url,
+		Debug:         debug,
+		ready:         make(chan struct{}),
+		pending:       make(map[uint64]*wsRequest),
+		subscriptions: make(map[string]*Subscription),
+		allSubs:       make(map[*Subscription]struct{}),
+		closed:        make(chan struct{}),
+	}
+
+	log.Debug("WS BaseURL : ", url)
+
+	go c.run()
+
+	return &c
+}
+
+// Close 关闭连接，未完成的请求返回错误，所有订阅被关闭
+func (c *WSClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.connLock.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.connLock.Unlock()
+
+		c.subsLock.Lock()
+		for _, sub := range c.subscriptions {
+			sub.closed = true
+			sub.closeChannel()
+		}
+		for sub := range c.allSubs {
+			sub.closed = true
+			sub.closeChannel()
+		}
+		c.allSubs = make(map[*Subscription]struct{})
+		c.subscriptions = make(map[string]*Subscription)
+		c.subsLock.Unlock()
+	})
+}
+
+// Call 发送请求并等待响应，返回 result 字段
+func (c *WSClient) Call(method string, params []interface{}) (*gjson.Result, error) {
+	return c.call(method, params, nil)
+}
+
+// BatchCall 在同一条连接上并发发送多个请求，结果与 requests 顺序一致，任一请求出错时返回错误
+func (c *WSClient) BatchCall(requests []RpcRequest) ([]*gjson.Result, error) {
+	results := make([]*gjson.Result, len(requests))
+	errs := make([]error, len(requests))
+
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		wg.Add(1)
+		go func(i int, request RpcRequest) {
+			defer wg.Done()
+			results[i], errs[i] = c.call(request.Method, request.Params, nil)
+		}(i, request)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Subscribe 发起订阅，如 chain_subscribeFinalizedHeads，unsubscribeMethod 用于取消订阅
+func (c *WSClient) Subscribe(method, unsubscribeMethod string, params []interface{}) (*Subscription, error) {
+	ch := make(chan *gjson.Result, wsSubscriptionBuffer)
+	sub := &Subscription{
+		C:                 ch,
+		ch:                ch,
+		client:            c,
+		method:            method,
+		unsubscribeMethod: unsubscribeMethod,
+		params:            params,
+		quit:              make(chan struct{}),
+	}
+
+	_, err := c.call(method, params, sub)
+
+	c.subsLock.Lock()
+	defer c.subsLock.Unlock()
+
+	//超时后才收到的响应不再登记
+	if err != nil {
+		sub.closed = true
+		delete(c.subscriptions, sub.id)
+		return nil, err
+	}
+
+	if sub.closed {
+		return nil, errWSClosed
+	}
+	c.allSubs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+// Unsubscribe 取消订阅并关闭 C
+func (s *Subscription) Unsubscribe() error {
+	return s.unsubscribe(s.detach())
+}
+
+//detach 从客户端移除订阅并关闭 C，返回当前的订阅id
+func (s *Subscription) detach() string {
+	c := s.client
+
+	c.subsLock.Lock()
+	id := s.id
+	s.closed = true
+	delete(c.allSubs, s)
+	delete(c.subscriptions, id)
+	c.subsLock.Unlock()
+
+	s.closeChannel()
+
+	return id
+}
+
+//unsubscribe 通知节点取消订阅
+func (s *Subscription) unsubscribe(id string) error {
+	if len(id) == 0 || len(s.unsubscribeMethod) == 0 {
+		return nil
+	}
+
+	_, err := s.client.Call(s.unsubscribeMethod, []interface{}{id})
+	return err
+}
+
+//send 推送到 C，缓冲已满时最多等待 wsSubscriptionSendTimeout，超时返回 false
+func (s *Subscription) send(result *gjson.Result) bool {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	select {
+	case <-s.quit:
+		return true
+	default:
+	}
+
+	select {
+	case s.ch <- result:
+		return true
+	case <-s.quit:
+		return true
+	case <-time.After(wsSubscriptionSendTimeout):
+		return false
+	}
+}
+
+func (s *Subscription) closeChannel() {
+	s.closeOnce.Do(func() {
+		close(s.quit)
+
+		s.sendLock.Lock()
+		close(s.ch)
+		s.sendLock.Unlock()
+	})
+}
+
+func (c *WSClient) call(method string, params []interface{}, sub *Subscription) (*gjson.Result, error) {
+	conn, err := c.waitConn()
+	if err != nil {
+		return nil, err
+	}
+
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	id := atomic.AddUint64(&c.nextID, 1)
+	request := &wsRequest{
+		response: make(chan *gjson.Result, 1),
+		sub:      sub,
+	}
+
+	c.pendingLock.Lock()
+	c.pending[id] = request
+	c.pendingLock.Unlock()
+
+	defer func() {
+		c.pendingLock.Lock()
+		delete(c.pending, id)
+		c.pendingLock.Unlock()
+	}()
+
+	body := make(map[string]interface{}, 0)
+	body["jsonrpc"] = "2.0"
+	body["id"] = id
+	body["method"] = method
+	body["params"] = params
+
+	if c.Debug {
+		log.Debug("url : ", c.BaseURL, ", body : ", body)
+	}
+
+	c.writeLock.Lock()
+	err = conn.WriteJSON(body)
+	c.writeLock.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case resp := <-request.response:
+		if resp == nil {
+			return nil, errWSDisconnected
+		}
+
+		if c.Debug {
+			log.Debugf("%+v\n", resp.Raw)
+		}
+
+		err = isError(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		result := resp.Get("result")
+		return &result, nil
+	case <-time.After(wsRequestTimeout):
+		return nil, errors.New("websocket request timeout: " + method)
+	case <-c.closed:
+		return nil, errWSClosed
+	}
+}
+
+//waitConn 等待连接建立
+func (c *WSClient) waitConn() (*websocket.Conn, error) {
+	c.connLock.Lock()
+	conn, ready := c.conn, c.ready
+	c.connLock.Unlock()
+
+	if conn != nil {
+		return conn, nil
+	}
+
+	select {
+	case <-ready:
+	case <-time.After(wsRequestTimeout):
+		return nil, errors.New("websocket connect timeout: " + c.BaseURL)
+	case <-c.closed:
+		return nil, errWSClosed
+	}
+
+	c.connLock.Lock()
+	conn = c.conn
+	c.connLock.Unlock()
+
+	if conn == nil {
+		return nil, errWSDisconnected
+	}
+	return conn, nil
+}
+
+//run 维持连接，断线后按退避时间重连
+func (c *WSClient) run() {
+	wait := wsReconnectMinWait
+
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(c.BaseURL, nil)
+		if err != nil {
+			log.Error("websocket dial failed : ", c.BaseURL, ", ", err)
+
+			select {
+			case <-time.After(wait):
+			case <-c.closed:
+				return
+			}
+
+			wait *= 2
+			if wait > wsReconnectMaxWait {
+				wait = wsReconnectMaxWait
+			}
+			continue
+		}
+		wait = wsReconnectMinWait
+
+		c.connLock.Lock()
+		select {
+		case <-c.closed:
+			c.connLock.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		c.conn = conn
+		close(c.ready)
+		c.connLock.Unlock()
+
+		go c.resubscribe()
+
+		err = c.readLoop(conn)
+
+		c.connLock.Lock()
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.connLock.Unlock()
+		conn.Close()
+
+		c.failPending()
+		c.closeOneShotSubscriptions()
+
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+
+		log.Error("websocket disconnected : ", c.BaseURL, ", ", err)
+	}
+}
+
+func (c *WSClient) readLoop(conn *websocket.Conn) error {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		resp := gjson.ParseBytes(message)
+
+		//订阅推送
+		if resp.Get("method").Exists() && resp.Get("params.subscription").Exists() {
+			c.dispatch(&resp)
+			continue
+		}
+
+		id := resp.Get("id").Uint()
+
+		c.pendingLock.Lock()
+		request, ok := c.pending[id]
+		delete(c.pending, id)
+		c.pendingLock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		//在读取下一条消息之前登记订阅id，保证不会丢失第一条推送
+		if request.sub != nil && isError(&resp) == nil {
+			c.subsLock.Lock()
+			if !request.sub.closed {
+				request.sub.id = resp.Get("result").String()
+				c.subscriptions[request.sub.id] = request.sub
+			}
+			c.subsLock.Unlock()
+		}
+
+		request.response <- &resp
+	}
+}
+
+func (c *WSClient) dispatch(resp *gjson.Result) {
+	id := resp.Get("params.subscription").String()
+
+	c.subsLock.Lock()
+	sub, ok := c.subscriptions[id]
+	c.subsLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	//不丢弃推送，消费过慢时关闭订阅，使用方在 C 关闭后重新查询
+	result := resp.Get("params.result")
+	if !sub.send(&result) {
+		log.Warning("websocket subscription consumer too slow, close subscription : ", sub.method)
+		id := sub.detach()
+		go sub.unsubscribe(id)
+	}
+}
+
+//failPending 连接断开时，未完成的请求立即返回错误
+func (c *WSClient) failPending() {
+	c.pendingLock.Lock()
+	for id, request := range c.pending {
+		request.response <- nil
+		delete(c.pending, id)
+	}
+	c.pendingLock.Unlock()
+}
+
+//closeOneShotSubscriptions 断线时关闭不能重新发起的订阅
+func (c *WSClient) closeOneShotSubscriptions() {
+	c.subsLock.Lock()
+	subs := make([]*Subscription, 0)
+	for sub := range c.allSubs {
+		if wsOneShotSubscriptions[sub.method] {
+			subs = append(subs, sub)
+		}
+	}
+	c.subsLock.Unlock()
+
+	for _, sub := range subs {
+		log.Warning("websocket disconnected, close subscription : ", sub.method)
+		sub.detach()
+	}
+}
+
+//resubscribe 重连后重新订阅，订阅id会发生变化
+func (c *WSClient) resubscribe() {
+	c.subsLock.Lock()
+	c.subscriptions = make(map[string]*Subscription)
+	subs := make([]*Subscription, 0, len(c.allSubs))
+	for sub := range c.allSubs {
+		sub.id = ""
+		subs = append(subs, sub)
+	}
+	c.subsLock.Unlock()
+
+	for _, sub := range subs {
+		_, err := c.call(sub.method, sub.params, sub)
+		if err != nil {
+			log.Error("websocket resubscribe failed : ", sub.method, ", ", err)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package cennz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	testWSGenesisHash = "0x0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0"
+)
+
+//testWSRequest 测试节点收到的请求，订阅请求带有返回的订阅id
+type testWSRequest struct {
+	Method string
+	SubID  string
+}
+
+//testWSConn 测试节点上的一条连接
+type testWSConn struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+	requests  chan testWSRequest
+}
+
+func (c *testWSConn) write(v interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+//push 向订阅推送内容
+func (c *testWSConn) push(method, subID string, result interface{}) error {
+	return c.write(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params": map[string]interface{}{
+			"subscription": subID,
+			"result":       result,
+		},
+	})
+}
+
+//nextRequest 等待连接上的下一个请求
+func (c *testWSConn) nextRequest(t *testing.T) testWSRequest {
+	select {
+	case request := <-c.requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request received")
+		return testWSRequest{}
+	}
+}
+
+//testWSNode 按脚本响应请求的 websocket 节点，每条新连接从 conns 读取
+type testWSNode struct {
+	server *httptest.Server
+	conns  chan *testWSConn
+	subSeq uint64
+}
+
+func newTestWSNode() *testWSNode {
+	node := &testWSNode{
+		conns: make(chan *testWSConn, 4),
+	}
+
+	upgrader := websocket.Upgrader{}
+	node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		wsConn := &testWSConn{conn: conn, requests: make(chan testWSRequest, 16)}
+		node.conns <- wsConn
+		node.serve(wsConn)
+	}))
+
+	return node
+}
+
+func (node *testWSNode) url() string {
+	return "ws" + strings.TrimPrefix(node.server.URL, "http")
+}
+
+//nextConn 等待客户端建立新连接
+func (node *testWSNode) nextConn(t *testing.T) *testWSConn {
+	select {
+	case conn := <-node.conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection established")
+		return nil
+	}
+}
+
+func (node *testWSNode) serve(c *testWSConn) {
+	defer c.conn.Close()
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		request := gjson.ParseBytes(message)
+		record := testWSRequest{Method: request.Get("method").String()}
+
+		var result interface{}
+		switch record.Method {
+		case "chain_getBlockHash":
+			result = testWSGenesisHash
+		case "chain_subscribeFinalizedHeads", "author_submitAndWatchExtrinsic":
+			record.SubID = fmt.Sprintf("sub-%d", atomic.AddUint64(&node.subSeq, 1))
+			result = record.SubID
+		default:
+			result = true
+		}
+
+		if c.write(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      request.Get("id").Uint(),
+			"result":  result,
+		}) != nil {
+			return
+		}
+		c.requests <- record
+	}
+}
+
+func (node *testWSNode) Close() {
+	node.server.Close()
+}
+
+//receiveHeight 从订阅读取一个区块头的高度
+func receiveHeight(t *testing.T, sub *Subscription) uint64 {
+	select {
+	case header, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		height, err := parseBlockNumber(header)
+		if err != nil {
+			t.Fatal("parseBlockNumber failed : ", err)
+		}
+		return height
+	case <-time.After(5 * time.Second):
+		t.Fatal("no finalized head received")
+		return 0
+	}
+}
+
+func TestWSGetCall(t *testing.T) {
+	node := newTestWSNode()
+	defer node.Close()
+
+	client := NewWSRpcClient(node.url(), false, symbol)
+	defer client.ws.Close()
+
+	hash, err := client.GetGenesisHash()
+	if err != nil {
+		t.Fatalf("GetGenesisHash failed, err=%v", err)
+	}
+	if hash != testWSGenesisHash {
+		t.Error("wrong genesis hash : ", hash)
+	}
+}
+
+func TestWSSubscribeFinalizedHeads(t *testing.T) {
+	node := newTestWSNode()
+	defer node.Close()
+
+	client := NewWSRpcClient(node.url(), false, symbol)
+	defer client.ws.Close()
+
+	sub, err := client.Subscribe("chain_subscribeFinalizedHeads", "chain_unsubscribeFinalizedHeads", nil)
+	if err != nil {
+		t.Fatalf("Subscribe failed, err=%v", err)
+	}
+
+	conn := node.nextConn(t)
+	request := conn.nextRequest(t)
+	if request.Method != "chain_subscribeFinalizedHeads" {
+		t.Fatal("wrong subscribe method : ", request.Method)
+	}
+
+	for i := uint64(100); i < 103; i++ {
+		conn.push("chain_finalizedHead", request.SubID, map[string]string{"number": fmt.Sprintf("0x%x", i)})
+	}
+	for i := uint64(100); i < 103; i++ {
+		if height := receiveHeight(t, sub); height != i {
+			t.Fatal("wrong finalized height : ", height, ", expect : ", i)
+		}
+	}
+
+	//取消订阅时通知节点
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe failed, err=%v", err)
+	}
+	if request := conn.nextRequest(t); request.Method != "chain_unsubscribeFinalizedHeads" {
+		t.Error("wrong unsubscribe method : ", request.Method)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription is not closed after unsubscribe")
+	}
+}
+
+func TestWSSubmitAndWatchExtrinsic(t *testing.T) {
+	node := newTestWSNode()
+	defer node.Close()
+
+	client := NewWSRpcClient(node.url(), false, symbol)
+	defer client.ws.Close()
+
+	sub, err := client.SubmitAndWatchExtrinsic("0xa1a1a1a1a")
+	if err != nil {
+		t.Fatalf("SubmitAndWatchExtrinsic failed, err=%v", err)
+	}
+	defer sub.Unsubscribe()
+
+	conn := node.nextConn(t)
+	request := conn.nextRequest(t)
+	conn.push("author_extrinsicUpdate", request.SubID, "ready")
+	conn.push("author_extrinsicUpdate", request.SubID, map[string]string{"inBlock": "0x01"})
+	conn.push("author_extrinsicUpdate", request.SubID, map[string]string{"finalized": "0x01"})
+
+	expected := []string{SubmitStatusReady, SubmitStatusInBlock, SubmitStatusFinalized}
+	for _, expect := range expected {
+		select {
+		case result := <-sub.C:
+			status, _ := parseSubmitStatus(result)
+			if status != expect {
+				t.Fatal("wrong status : ", status, ", expect : ", expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no status received, expect : ", expect)
+		}
+	}
+}
+
+func TestWSReconnectResubscribe(t *testing.T) {
+	node := newTestWSNode()
+	defer node.Close()
+
+	client := NewWSRpcClient(node.url(), false, symbol)
+	defer client.ws.Close()
+
+	heads, err := client.Subscribe("chain_subscribeFinalizedHeads", "chain_unsubscribeFinalizedHeads", nil)
+	if err != nil {
+		t.Fatalf("Subscribe failed, err=%v", err)
+	}
+	watch, err := client.SubmitAndWatchExtrinsic("0xa1a1a1a1a")
+	if err != nil {
+		t.Fatalf("SubmitAndWatchExtrinsic failed, err=%v", err)
+	}
+
+	conn := node.nextConn(t)
+	first := conn.nextRequest(t)
+	conn.nextRequest(t)
+	conn.push("chain_finalizedHead", first.SubID, map[string]string{"number": "0x64"})
+	if height := receiveHeight(t, heads); height != 100 {
+		t.Fatal("wrong finalized height : ", height)
+	}
+
+	//节点断开连接
+	conn.conn.Close()
+
+	//广播交易的订阅被关闭
+	select {
+	case _, ok := <-watch.C:
+		if ok {
+			t.Fatal("watch subscription received a notification after disconnect")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch subscription is not closed after disconnect")
+	}
+
+	//重连后只重新订阅区块头，订阅id发生变化
+	conn = node.nextConn(t)
+	request := conn.nextRequest(t)
+	if request.Method != "chain_subscribeFinalizedHeads" {
+		t.Fatal("wrong resubscribe method : ", request.Method)
+	}
+	if request.SubID == first.SubID {
+		t.Fatal("subscription id is not changed")
+	}
+
+	conn.push("chain_finalizedHead", first.SubID, map[string]string{"number": "0x65"})
+	conn.push("chain_finalizedHead", request.SubID, map[string]string{"number": "0x66"})
+	if height := receiveHeight(t, heads); height != 102 {
+		t.Fatal("wrong finalized height after reconnect : ", height)
+	}
+
+	select {
+	case request := <-conn.requests:
+		t.Error("unexpected request after reconnect : ", request.Method)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func newTestWSSubscription() (*WSClient, *Subscription) {
+	client := &WSClient{
+		subscriptions: make(map[string]*Subscription),
+		allSubs:       make(map[*Subscription]struct{}),
+		closed:        make(chan struct{}),
+	}
+	ch := make(chan *gjson.Result, wsSubscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, client: client, id: "1", quit: make(chan struct{})}
+	client.subscriptions[sub.id] = sub
+	client.allSubs[sub] = struct{}{}
+	return client, sub
+}
+
+func TestWSDispatchNoDrop(t *testing.T) {
+	client, sub := newTestWSSubscription()
+
+	total := wsSubscriptionBuffer * 3
+	go func() {
+		for i := 0; i < total; i++ {
+			resp := gjson.Parse(fmt.Sprintf(`{"method":"test","params":{"subscription":"1","result":%d}}`, i))
+			client.dispatch(&resp)
+		}
+	}()
+
+	//消费慢于推送时不丢弃
+	for i := 0; i < total; i++ {
+		select {
+		case result := <-sub.C:
+			if result.Int() != int64(i) {
+				t.Fatal("wrong notification : ", result.Int(), ", expect : ", i)
+			}
+		case <-time.After(wsSubscriptionSendTimeout):
+			t.Fatal("notification lost : ", i)
+		}
+		if i%wsSubscriptionBuffer == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestWSDispatchUnsubscribeWhileFull(t *testing.T) {
+	client, sub := newTestWSSubscription()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i <= wsSubscriptionBuffer; i++ {
+			resp := gjson.Parse(fmt.Sprintf(`{"method":"test","params":{"subscription":"1","result":%d}}`, i))
+			client.dispatch(&resp)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	sub.detach()
+
+	//取消订阅后等待中的推送立即返回
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked after unsubscribe")
+	}
+
+	count := 0
+	for range sub.C {
+		count++
+	}
+	if count != wsSubscriptionBuffer {
+		t.Error("wrong buffered notifications : ", count)
+	}
+}
+
+func TestWSCloseOneShotSubscriptions(t *testing.T) {
+	client, sub := newTestWSSubscription()
+	sub.method = "chain_subscribeFinalizedHeads"
+
+	ch := make(chan *gjson.Result, wsSubscriptionBuffer)
+	watch := &Subscription{C: ch, ch: ch, client: client, id: "2", method: "author_submitAndWatchExtrinsic", quit: make(chan struct{})}
+	client.subscriptions[watch.id] = watch
+	client.allSubs[watch] = struct{}{}
+
+	//断线后广播交易的订阅被关闭，不会重新发起
+	client.closeOneShotSubscriptions()
+
+	if _, ok := <-watch.C; ok {
+		t.Error("watch subscription is not closed")
+	}
+	if _, ok := client.allSubs[watch]; ok {
+		t.Error("watch subscription will be resubscribed")
+	}
+	if _, ok := client.allSubs[sub]; !ok {
+		t.Error("finalized heads subscription is closed")
+	}
+}
@@ -0,0 +1,416 @@
+package cennzTransaction
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+var (
+	maxUint128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+	// compact 大整数模式最多支持 67 字节
+	maxCompactBigInt = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 536), big.NewInt(1))
+)
+
+// 解码时允许的最大长度，避免错误数据导致过大的内存分配
+const maxDecodeLength = 1 << 26
+
+// ScaleEncoder SCALE 编码器，按调用顺序写入，元组即依次编码各个元素
+type ScaleEncoder struct {
+	buf bytes.Buffer
+}
+
+func NewScaleEncoder() *ScaleEncoder {
+	return &ScaleEncoder{}
+}
+
+// Bytes 返回已编码的数据
+func (e *ScaleEncoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+// Hex 返回已编码数据的16进制字符串，不带 0x
+func (e *ScaleEncoder) Hex() string {
+	return hex.EncodeToString(e.buf.Bytes())
+}
+
+func (e *ScaleEncoder) EncodeU8(v uint8) {
+	e.buf.WriteByte(v)
+}
+
+func (e *ScaleEncoder) EncodeU16(v uint16) {
+	tmp := [2]byte{}
+	binary.LittleEndian.PutUint16(tmp[:], v)
+	e.buf.Write(tmp[:])
+}
+
+func (e *ScaleEncoder) EncodeU32(v uint32) {
+	tmp := [4]byte{}
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	e.buf.Write(tmp[:])
+}
+
+func (e *ScaleEncoder) EncodeU64(v uint64) {
+	tmp := [8]byte{}
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	e.buf.Write(tmp[:])
+}
+
+func (e *ScaleEncoder) EncodeU128(v *big.Int) error {
+	if v == nil || v.Sign() < 0 || v.Cmp(maxUint128) > 0 {
+		return errors.New("invalid u128 value")
+	}
+	e.buf.Write(bigIntToLittleEndianBytes(v, 16))
+	return nil
+}
+
+func (e *ScaleEncoder) EncodeBool(v bool) {
+	if v {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+}
+
+// EncodeCompact 编码 compact 整数
+func (e *ScaleEncoder) EncodeCompact(v uint64) error {
+	return e.EncodeCompactBigInt(new(big.Int).SetUint64(v))
+}
+
+// CompactBytes 返回单个 compact 整数的编码
+func CompactBytes(v uint64) ([]byte, error) {
+	e := NewScaleEncoder()
+	if err := e.EncodeCompact(v); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// EncodeCompactBigInt 编码任意长度（最多 2^536-1）的 compact 整数
+func (e *ScaleEncoder) EncodeCompactBigInt(v *big.Int) error {
+	if v == nil || v.Sign() < 0 || v.Cmp(maxCompactBigInt) > 0 {
+		return errors.New("invalid compact value")
+	}
+
+	if v.IsUint64() && v.Uint64() <= fourByteModeMaxValue {
+		data := v.Uint64()
+		switch {
+		case data <= singleModeMaxValue:
+			e.EncodeU8(uint8(data<<modeBits) | singleMode)
+		case data <= twoByteModeMaxValue:
+			e.EncodeU16(uint16(data<<modeBits) | uint16(twoByteMode))
+		default:
+			e.EncodeU32(uint32(data<<modeBits) | uint32(fourByteMode))
+		}
+		return nil
+	}
+
+	length := (v.BitLen() + 7) / 8
+	if length < 4 {
+		length = 4
+	}
+	e.buf.WriteByte(byte((length-4)<<modeBits) | bigIntMode)
+	e.buf.Write(bigIntToLittleEndianBytes(v, length))
+	return nil
+}
+
+// EncodeBytes 编码 Vec<u8>
+func (e *ScaleEncoder) EncodeBytes(v []byte) error {
+	if err := e.EncodeCompact(uint64(len(v))); err != nil {
+		return err
+	}
+	e.buf.Write(v)
+	return nil
+}
+
+// EncodeFixedBytes 编码定长字节数组 [u8; N]
+func (e *ScaleEncoder) EncodeFixedBytes(v []byte) {
+	e.buf.Write(v)
+}
+
+func (e *ScaleEncoder) EncodeString(v string) error {
+	return e.EncodeBytes([]byte(v))
+}
+
+// EncodeOption 编码 Option<T>，some 为 false 时只写入 None 标记
+func (e *ScaleEncoder) EncodeOption(some bool, encode func(e *ScaleEncoder) error) error {
+	if !some {
+		e.buf.WriteByte(0)
+		return nil
+	}
+	e.buf.WriteByte(1)
+	return encode(e)
+}
+
+// EncodeVec 编码 Vec<T>，逐个回调编码元素
+func (e *ScaleEncoder) EncodeVec(length int, encode func(i int, e *ScaleEncoder) error) error {
+	if err := e.EncodeCompact(uint64(length)); err != nil {
+		return err
+	}
+	for i := 0; i < length; i++ {
+		if err := encode(i, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeEnum 编码枚举，先写入变体序号，再编码变体内容
+func (e *ScaleEncoder) EncodeEnum(index uint8, encode func(e *ScaleEncoder) error) error {
+	e.buf.WriteByte(index)
+	if encode == nil {
+		return nil
+	}
+	return encode(e)
+}
+
+// ScaleDecoder SCALE 流式解码器，按顺序从 reader 中读取，元组即依次解码各个元素
+type ScaleDecoder struct {
+	reader *bufio.Reader
+}
+
+func NewScaleDecoder(r io.Reader) *ScaleDecoder {
+	return &ScaleDecoder{reader: bufio.NewReader(r)}
+}
+
+func NewScaleDecoderFromBytes(data []byte) *ScaleDecoder {
+	return NewScaleDecoder(bytes.NewReader(data))
+}
+
+// NewScaleDecoderFromHex 可以带 0x 前缀
+func NewScaleDecoderFromHex(data string) (*ScaleDecoder, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	return NewScaleDecoderFromBytes(raw), nil
+}
+
+// HasRemaining 是否还有未读取的数据
+func (d *ScaleDecoder) HasRemaining() bool {
+	_, err := d.reader.Peek(1)
+	return err == nil
+}
+
+// ReadAll 读取剩余的全部数据
+func (d *ScaleDecoder) ReadAll() ([]byte, error) {
+	return ioutil.ReadAll(d.reader)
+}
+
+func (d *ScaleDecoder) ReadBytes(length int) ([]byte, error) {
+	if length < 0 {
+		return nil, errors.New("invalid length to read")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(d.reader, buf); err != nil {
+		return nil, fmt.Errorf("read %d bytes failed: %v", length, err)
+	}
+	return buf, nil
+}
+
+func (d *ScaleDecoder) DecodeU8() (uint8, error) {
+	b, err := d.reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("read byte failed: %v", err)
+	}
+	return b, nil
+}
+
+func (d *ScaleDecoder) DecodeU16() (uint16, error) {
+	buf, err := d.ReadBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(buf), nil
+}
+
+func (d *ScaleDecoder) DecodeU32() (uint32, error) {
+	buf, err := d.ReadBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+func (d *ScaleDecoder) DecodeU64() (uint64, error) {
+	buf, err := d.ReadBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func (d *ScaleDecoder) DecodeU128() (*big.Int, error) {
+	buf, err := d.ReadBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return littleEndianBytesToBigInt(buf), nil
+}
+
+func (d *ScaleDecoder) DecodeBool() (bool, error) {
+	b, err := d.DecodeU8()
+	if err != nil {
+		return false, err
+	}
+	switch b {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid bool value %d", b)
+	}
+}
+
+// DecodeCompact 解码 compact 整数，只接受最短的编码，同一个值只有一种编码
+func (d *ScaleDecoder) DecodeCompact() (*big.Int, error) {
+	first, err := d.DecodeU8()
+	if err != nil {
+		return nil, err
+	}
+
+	switch first & 0x03 {
+	case singleMode:
+		return big.NewInt(int64(first >> modeBits)), nil
+	case twoByteMode:
+		next, err := d.DecodeU8()
+		if err != nil {
+			return nil, err
+		}
+		v := binary.LittleEndian.Uint16([]byte{first, next}) >> modeBits
+		if v <= singleModeMaxValue {
+			return nil, errors.New("non-canonical compact encoding")
+		}
+		return big.NewInt(int64(v)), nil
+	case fourByteMode:
+		next, err := d.ReadBytes(3)
+		if err != nil {
+			return nil, err
+		}
+		v := binary.LittleEndian.Uint32(append([]byte{first}, next...)) >> modeBits
+		if v <= twoByteModeMaxValue {
+			return nil, errors.New("non-canonical compact encoding")
+		}
+		return big.NewInt(int64(v)), nil
+	default:
+		length := int(first>>modeBits) + 4
+		buf, err := d.ReadBytes(length)
+		if err != nil {
+			return nil, err
+		}
+		//最高字节不能为0，4字节时必须超过4字节模式的上限
+		if buf[length-1] == 0 {
+			return nil, errors.New("non-canonical compact encoding")
+		}
+		v := littleEndianBytesToBigInt(buf)
+		if v.IsUint64() && v.Uint64() <= fourByteModeMaxValue {
+			return nil, errors.New("non-canonical compact encoding")
+		}
+		return v, nil
+	}
+}
+
+// DecodeCompactUint64 解码不超过 uint64 的 compact 整数
+func (d *ScaleDecoder) DecodeCompactUint64() (uint64, error) {
+	v, err := d.DecodeCompact()
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsUint64() {
+		return 0, errors.New("compact value overflows uint64")
+	}
+	return v.Uint64(), nil
+}
+
+// DecodeBytes 解码 Vec<u8>
+func (d *ScaleDecoder) DecodeBytes() ([]byte, error) {
+	length, err := d.decodeLength()
+	if err != nil {
+		return nil, err
+	}
+	return d.ReadBytes(length)
+}
+
+// DecodeFixedBytes 解码定长字节数组 [u8; N]
+func (d *ScaleDecoder) DecodeFixedBytes(length int) ([]byte, error) {
+	return d.ReadBytes(length)
+}
+
+func (d *ScaleDecoder) DecodeString() (string, error) {
+	buf, err := d.DecodeBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// DecodeOption 解码 Option<T>，为 Some 时回调解码内容
+func (d *ScaleDecoder) DecodeOption(decode func(d *ScaleDecoder) error) (bool, error) {
+	flag, err := d.DecodeU8()
+	if err != nil {
+		return false, err
+	}
+	switch flag {
+	case 0:
+		return false, nil
+	case 1:
+		return true, decode(d)
+	default:
+		return false, fmt.Errorf("invalid option flag %d", flag)
+	}
+}
+
+// DecodeVec 解码 Vec<T>，逐个回调解码元素，返回元素个数
+func (d *ScaleDecoder) DecodeVec(decode func(i int, d *ScaleDecoder) error) (int, error) {
+	length, err := d.decodeLength()
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < length; i++ {
+		if err := decode(i, d); err != nil {
+			return i, err
+		}
+	}
+	return length, nil
+}
+
+// DecodeEnum 读取枚举的变体序号，变体内容由调用方继续解码
+func (d *ScaleDecoder) DecodeEnum() (uint8, error) {
+	return d.DecodeU8()
+}
+
+func (d *ScaleDecoder) decodeLength() (int, error) {
+	length, err := d.DecodeCompactUint64()
+	if err != nil {
+		return 0, err
+	}
+	if length > maxDecodeLength {
+		return 0, fmt.Errorf("invalid length %d", length)
+	}
+	return int(length), nil
+}
+
+func bigIntToLittleEndianBytes(v *big.Int, length int) []byte {
+	be := v.Bytes()
+	le := make([]byte, length)
+	for i := 0; i < len(be) && i < length; i++ {
+		le[i] = be[len(be)-1-i]
+	}
+	return le
+}
+
+func littleEndianBytesToBigInt(le []byte) *big.Int {
+	be := make([]byte, len(le))
+	for i := range le {
+		be[len(le)-1-i] = le[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
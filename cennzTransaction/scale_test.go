@@ -0,0 +1,192 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func Test_ScaleCompact(t *testing.T) {
+	testTable := map[string]string{
+		"0":                    "00",
+		"1":                    "04",
+		"63":                   "fc",
+		"64":                   "0101",
+		"16383":                "fdff",
+		"16384":                "02000100",
+		"1073741823":           "feffffff",
+		"1073741824":           "0300000040",
+		"14102610000":          "0750c0944803",
+		"18446744073709551615": "13ffffffffffffffff",
+		"340282366920938463463374607431768211455": "33ffffffffffffffffffffffffffffffff",
+	}
+
+	for number, excepted := range testTable {
+		v, _ := new(big.Int).SetString(number, 10)
+
+		e := NewScaleEncoder()
+		if err := e.EncodeCompactBigInt(v); err != nil {
+			t.Error(number, " encode failed : ", err)
+			continue
+		}
+		if e.Hex() != excepted {
+			t.Error(number, " encode failed, got ", e.Hex())
+			continue
+		}
+
+		d, _ := NewScaleDecoderFromHex(excepted)
+		decoded, err := d.DecodeCompact()
+		if err != nil || decoded.Cmp(v) != 0 {
+			t.Error(number, " decode failed : ", decoded, err)
+		}
+		if d.HasRemaining() {
+			t.Error(number, " decode left bytes")
+		}
+	}
+}
+
+func Test_ScaleCompactUint64(t *testing.T) {
+	testTable := map[uint64]string{
+		2:           "08",
+		128:         "0102",
+		512:         "0108",
+		4102610000:  "0350dc88f4",
+		14102610000: "0750c0944803",
+	}
+
+	for number, excepted := range testTable {
+		encoded, err := CompactBytes(number)
+		if err != nil || hex.EncodeToString(encoded) != excepted {
+			t.Error(number, " encode failed, got ", hex.EncodeToString(encoded), err)
+		}
+	}
+}
+
+func Test_ScaleCompactNonCanonical(t *testing.T) {
+	//同一个值使用更长的模式编码
+	testTable := map[string]string{
+		"1 in two byte mode":             "0500",
+		"63 in two byte mode":            "fd00",
+		"64 in four byte mode":           "02010000",
+		"16383 in four byte mode":        "feff0000",
+		"1073741823 in big int mode":     "03ffffff3f",
+		"2^32 with leading zero byte":    "0b000000000100",
+		"u64 max with leading zero byte": "17ffffffffffffffff00",
+	}
+
+	for name, encoded := range testTable {
+		d, _ := NewScaleDecoderFromHex(encoded)
+		if v, err := d.DecodeCompact(); err == nil {
+			t.Error(name, " should be rejected, decoded ", v)
+		}
+	}
+}
+
+func Test_ScaleFixedWidth(t *testing.T) {
+	u128, _ := new(big.Int).SetString("1000000000000000000000", 10)
+
+	e := NewScaleEncoder()
+	e.EncodeU8(0x2a)
+	e.EncodeU16(0x0102)
+	e.EncodeU32(0x01020304)
+	e.EncodeU64(0x0102030405060708)
+	if err := e.EncodeU128(u128); err != nil {
+		t.Error("encode u128 failed : ", err)
+		return
+	}
+	e.EncodeBool(true)
+
+	excepted := "2a" + "0201" + "04030201" + "0807060504030201" + "0000a0dec5adc9353600000000000000" + "01"
+	if e.Hex() != excepted {
+		t.Error("encode failed, got ", e.Hex())
+		return
+	}
+
+	d, _ := NewScaleDecoderFromHex("0x" + excepted)
+	u8, _ := d.DecodeU8()
+	u16, _ := d.DecodeU16()
+	u32, _ := d.DecodeU32()
+	u64, _ := d.DecodeU64()
+	decodedU128, _ := d.DecodeU128()
+	b, err := d.DecodeBool()
+	if err != nil || u8 != 0x2a || u16 != 0x0102 || u32 != 0x01020304 || u64 != 0x0102030405060708 || decodedU128.Cmp(u128) != 0 || !b {
+		t.Error("decode failed : ", u8, u16, u32, u64, decodedU128, b, err)
+	}
+
+	if err := NewScaleEncoder().EncodeU128(new(big.Int).Lsh(big.NewInt(1), 128)); err == nil {
+		t.Error("u128 overflow not detected")
+	}
+}
+
+func Test_ScaleContainers(t *testing.T) {
+	items := []uint32{1, 2, 3}
+
+	e := NewScaleEncoder()
+	// Option<u32>
+	e.EncodeOption(false, nil)
+	e.EncodeOption(true, func(e *ScaleEncoder) error {
+		e.EncodeU32(7)
+		return nil
+	})
+	// Vec<Compact<u32>>
+	e.EncodeVec(len(items), func(i int, e *ScaleEncoder) error {
+		e.EncodeCompact(uint64(items[i]))
+		return nil
+	})
+	// (Vec<u8>, [u8; 2])
+	e.EncodeString("cennz")
+	e.EncodeFixedBytes([]byte{0xaa, 0xbb})
+	// enum variant 1 with u16 payload
+	e.EncodeEnum(1, func(e *ScaleEncoder) error {
+		e.EncodeU16(300)
+		return nil
+	})
+
+	excepted := "00" + "0107000000" + "0c04080c" + "1463656e6e7a" + "aabb" + "012c01"
+	if e.Hex() != excepted {
+		t.Error("encode failed, got ", e.Hex())
+		return
+	}
+
+	raw, _ := hex.DecodeString(excepted)
+	d := NewScaleDecoderFromBytes(raw)
+
+	var option uint32
+	some, err := d.DecodeOption(func(d *ScaleDecoder) error {
+		return errors.New("none should not be decoded")
+	})
+	if some || err != nil {
+		t.Error("decode none failed")
+	}
+	some, err = d.DecodeOption(func(d *ScaleDecoder) error {
+		var err error
+		option, err = d.DecodeU32()
+		return err
+	})
+	if !some || err != nil || option != 7 {
+		t.Error("decode some failed")
+	}
+
+	decodedItems := make([]uint64, 0)
+	length, err := d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		item, err := d.DecodeCompactUint64()
+		decodedItems = append(decodedItems, item)
+		return err
+	})
+	if err != nil || length != 3 || decodedItems[2] != 3 {
+		t.Error("decode vec failed : ", decodedItems, err)
+	}
+
+	str, _ := d.DecodeString()
+	fixed, _ := d.DecodeFixedBytes(2)
+	index, _ := d.DecodeEnum()
+	payload, err := d.DecodeU16()
+	if err != nil || str != "cennz" || hex.EncodeToString(fixed) != "aabb" || index != 1 || payload != 300 {
+		t.Error("decode tuple and enum failed : ", str, fixed, index, payload, err)
+	}
+
+	if _, err := d.DecodeU8(); err == nil {
+		t.Error("read past end not detected")
+	}
+}
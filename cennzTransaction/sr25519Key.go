@@ -0,0 +1,219 @@
+package cennzTransaction
+
+import (
+	"errors"
+
+	"github.com/ChainSafe/go-schnorrkel"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/go-owcrypt/eddsa/edwards25519"
+)
+
+// 1/sqrt(a-d)，ristretto255 编码使用的常量
+var invSqrtAMinusD = feFromBytes([32]byte{
+	0xea, 0x40, 0x5d, 0x80, 0xaa, 0xfd, 0xc8, 0x99, 0xbe, 0x72, 0x41, 0x5a, 0x17, 0x16, 0x2f, 0x9d,
+	0x40, 0xd8, 0x01, 0xfe, 0x91, 0x7b, 0xc2, 0x16, 0xa2, 0xfc, 0xaf, 0xcf, 0x05, 0x89, 0x6c, 0x78,
+})
+
+// sr25519 私钥偏移量和 nonce 的派生域，与 ed25519 签名使用的数据区分开
+const (
+	sr25519TweakDomain = "cennz-sr25519-tweak-v1"
+	sr25519NonceDomain = "cennz-sr25519-nonce-v1"
+)
+
+// GetSr25519PublicKey 由 HD 派生的 ed25519 公钥计算 sr25519 公钥
+// sr25519 私钥为 k+t，k 为 HD 派生的私钥标量，t 由 ed25519 公钥派生，公钥 k·B+t·B 按 ristretto255 编码，
+// 因此只有公钥也能得到 sr25519 地址，两种签名不会使用同一个私钥标量
+func GetSr25519PublicKey(ed25519Pubkey []byte) ([]byte, error) {
+	if len(ed25519Pubkey) != 32 {
+		return nil, errors.New("invalid public key")
+	}
+
+	var (
+		point  edwards25519.ExtendedGroupElement
+		sum    edwards25519.ProjectiveGroupElement
+		in     [32]byte
+		one    [32]byte
+		summed [32]byte
+	)
+	copy(in[:], ed25519Pubkey)
+	if !point.FromBytes(&in) {
+		return nil, errors.New("invalid public key")
+	}
+
+	//1·P + t·B
+	one[0] = 1
+	tweak := sr25519Tweak(ed25519Pubkey)
+	edwards25519.GeDoubleScalarMultVartime(&sum, &one, &point, &tweak)
+	sum.ToBytes(&summed)
+	if !point.FromBytes(&summed) {
+		return nil, errors.New("invalid public key")
+	}
+
+	out := ristrettoEncode(&point)
+	return out[:], nil
+}
+
+// newSr25519SecretKey 由 HD 派生的 32 字节私钥计算 sr25519 私钥，私钥标量为 k+t，nonce 由私钥按独立的域派生
+func newSr25519SecretKey(prikey []byte) (*schnorrkel.SecretKey, error) {
+	if prikey == nil || len(prikey) != 32 {
+		return nil, errors.New("invalid private key")
+	}
+
+	var (
+		wide     [64]byte
+		k        [32]byte
+		one      [32]byte
+		key      [32]byte
+		nonce    [32]byte
+		edPoint  edwards25519.ExtendedGroupElement
+		edPubkey [32]byte
+	)
+	copy(wide[:], prikey)
+	edwards25519.ScReduce(&k, &wide)
+
+	edwards25519.GeScalarMultBase(&edPoint, &k)
+	edPoint.ToBytes(&edPubkey)
+
+	one[0] = 1
+	tweak := sr25519Tweak(edPubkey[:])
+	edwards25519.ScMulAdd(&key, &one, &k, &tweak)
+
+	copy(nonce[:], owcrypt.Hash(append([]byte(sr25519NonceDomain), prikey...), 64, owcrypt.HASH_ALG_BLAKE2B)[:32])
+
+	return schnorrkel.NewSecretKey(key, nonce), nil
+}
+
+// sr25519Tweak 私钥偏移量 t = blake2b-512(域 || ed25519 公钥) mod l
+func sr25519Tweak(ed25519Pubkey []byte) [32]byte {
+	var (
+		wide  [64]byte
+		tweak [32]byte
+	)
+	copy(wide[:], owcrypt.Hash(append([]byte(sr25519TweakDomain), ed25519Pubkey...), 64, owcrypt.HASH_ALG_BLAKE2B))
+	edwards25519.ScReduce(&tweak, &wide)
+	return tweak
+}
+
+// ristrettoEncode 按 ristretto255 规则编码 edwards25519 上的点
+func ristrettoEncode(p *edwards25519.ExtendedGroupElement) [32]byte {
+	var u1, u2, t, invSqrt, den1, den2, zInv, ix, iy, x, y, denInv, s edwards25519.FieldElement
+
+	edwards25519.FeAdd(&u1, &p.Z, &p.Y)
+	edwards25519.FeSub(&t, &p.Z, &p.Y)
+	edwards25519.FeMul(&u1, &u1, &t)
+	edwards25519.FeMul(&u2, &p.X, &p.Y)
+
+	edwards25519.FeSquare(&t, &u2)
+	edwards25519.FeMul(&t, &t, &u1)
+	feInvSqrt(&invSqrt, &t)
+
+	edwards25519.FeMul(&den1, &invSqrt, &u1)
+	edwards25519.FeMul(&den2, &invSqrt, &u2)
+	edwards25519.FeMul(&zInv, &den1, &den2)
+	edwards25519.FeMul(&zInv, &zInv, &p.T)
+
+	edwards25519.FeMul(&t, &p.T, &zInv)
+	if edwards25519.FeIsNegative(&t) == 1 {
+		edwards25519.FeMul(&ix, &p.X, &edwards25519.SqrtM1)
+		edwards25519.FeMul(&iy, &p.Y, &edwards25519.SqrtM1)
+		edwards25519.FeCopy(&x, &iy)
+		edwards25519.FeCopy(&y, &ix)
+		edwards25519.FeMul(&denInv, &den1, &invSqrtAMinusD)
+	} else {
+		edwards25519.FeCopy(&x, &p.X)
+		edwards25519.FeCopy(&y, &p.Y)
+		edwards25519.FeCopy(&denInv, &den2)
+	}
+
+	edwards25519.FeMul(&t, &x, &zInv)
+	if edwards25519.FeIsNegative(&t) == 1 {
+		edwards25519.FeNeg(&y, &y)
+	}
+
+	edwards25519.FeSub(&s, &p.Z, &y)
+	edwards25519.FeMul(&s, &s, &denInv)
+	if edwards25519.FeIsNegative(&s) == 1 {
+		edwards25519.FeNeg(&s, &s)
+	}
+
+	var out [32]byte
+	edwards25519.FeToBytes(&out, &s)
+	return out
+}
+
+// feInvSqrt 计算 1/sqrt(v)，v 不是平方数时返回 sqrt(i/v)，结果取非负值
+func feInvSqrt(out, v *edwards25519.FieldElement) {
+	var v3, v7, r, check, one, negOne, negOneI edwards25519.FieldElement
+
+	//r = v^3 * (v^7)^((p-5)/8)
+	edwards25519.FeSquare(&v3, v)
+	edwards25519.FeMul(&v3, &v3, v)
+	edwards25519.FeSquare(&v7, &v3)
+	edwards25519.FeMul(&v7, &v7, v)
+	fePow22523(&r, &v7)
+	edwards25519.FeMul(&r, &r, &v3)
+
+	edwards25519.FeSquare(&check, &r)
+	edwards25519.FeMul(&check, &check, v)
+
+	edwards25519.FeOne(&one)
+	edwards25519.FeNeg(&negOne, &one)
+	edwards25519.FeMul(&negOneI, &negOne, &edwards25519.SqrtM1)
+
+	if feEqual(&check, &negOne) || feEqual(&check, &negOneI) {
+		edwards25519.FeMul(&r, &r, &edwards25519.SqrtM1)
+	}
+	if edwards25519.FeIsNegative(&r) == 1 {
+		edwards25519.FeNeg(&r, &r)
+	}
+
+	edwards25519.FeCopy(out, &r)
+}
+
+// fePow22523 计算 z^((p-5)/8)
+func fePow22523(out, z *edwards25519.FieldElement) {
+	var t0, t1, t2 edwards25519.FieldElement
+
+	edwards25519.FeSquare(&t0, z)
+	feSquareTimes(&t1, &t0, 2)
+	edwards25519.FeMul(&t1, z, &t1)
+	edwards25519.FeMul(&t0, &t0, &t1)
+	edwards25519.FeSquare(&t0, &t0)
+	edwards25519.FeMul(&t0, &t1, &t0)
+	feSquareTimes(&t1, &t0, 5)
+	edwards25519.FeMul(&t0, &t1, &t0)
+	feSquareTimes(&t1, &t0, 10)
+	edwards25519.FeMul(&t1, &t1, &t0)
+	feSquareTimes(&t2, &t1, 20)
+	edwards25519.FeMul(&t1, &t2, &t1)
+	feSquareTimes(&t1, &t1, 10)
+	edwards25519.FeMul(&t0, &t1, &t0)
+	feSquareTimes(&t1, &t0, 50)
+	edwards25519.FeMul(&t1, &t1, &t0)
+	feSquareTimes(&t2, &t1, 100)
+	edwards25519.FeMul(&t1, &t2, &t1)
+	feSquareTimes(&t1, &t1, 50)
+	edwards25519.FeMul(&t0, &t1, &t0)
+	feSquareTimes(&t0, &t0, 2)
+	edwards25519.FeMul(out, &t0, z)
+}
+
+func feSquareTimes(out, z *edwards25519.FieldElement, n int) {
+	edwards25519.FeSquare(out, z)
+	for i := 1; i < n; i++ {
+		edwards25519.FeSquare(out, out)
+	}
+}
+
+func feEqual(a, b *edwards25519.FieldElement) bool {
+	var sa, sb [32]byte
+	edwards25519.FeToBytes(&sa, a)
+	edwards25519.FeToBytes(&sb, b)
+	return sa == sb
+}
+
+func feFromBytes(b [32]byte) edwards25519.FieldElement {
+	var fe edwards25519.FieldElement
+	edwards25519.FeFromBytes(&fe, &b)
+	return fe
+}
@@ -45,6 +45,7 @@ func VerifyAndCombineTransaction(emptyTrans, signature string) (string, bool) {
 		return "", false
 	}
 
+	//与签名时相同的消息，payload 过长时为其哈希
 	msg, _ := hex.DecodeString(tp.ToBytesString())
 
 	pubkey, _ := hex.DecodeString(ts.SenderPubkey)
@@ -54,14 +55,28 @@ func VerifyAndCombineTransaction(emptyTrans, signature string) (string, bool) {
 		return "", false
 	}
 
-	if owcrypt.SUCCESS != owcrypt.Verify(pubkey, nil, msg, sig, owcrypt.ECC_CURVE_ED25519) {
+	if !VerifySignature(pubkey, msg, sig, ts.SignatureType) {
 		return "", false
 	}
 
-	signned, err := ts.GetSignedTransaction(Generic_Asset_Transfer, signature)
+	signned, err := ts.GetSignedTransaction(ts.transferCode(), signature)
 	if err != nil {
 		return "", false
 	}
 
 	return signned, true
-}
\ No newline at end of file
+}
+
+// GetTransactionHash 计算签名交易单的交易哈希，即包含长度前缀的完整交易单的 blake2b-256
+func GetTransactionHash(signed string) (string, error) {
+	if len(signed) >= 2 && signed[:2] == "0x" {
+		signed = signed[2:]
+	}
+
+	signedBytes, err := hex.DecodeString(signed)
+	if err != nil || len(signedBytes) == 0 {
+		return "", errors.New("invalid signed transaction")
+	}
+
+	return "0x" + hex.EncodeToString(owcrypt.Hash(signedBytes, 32, owcrypt.HASH_ALG_BLAKE2B)), nil
+}
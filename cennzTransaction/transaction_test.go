@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"testing"
 )
 
@@ -22,17 +23,17 @@ func Test_CENNZ_transaction(t *testing.T) {
 		//接收方公钥
 		RecipientPubkey: "7dd904f18b1e42c7f2b62429245771f51749a42be06c02bd4251df69f2c141df",
 		//发送金额（最小单位）
-		Amount:         1303822400,
+		Amount:         big.NewInt(1303822400),
 		//资产ID
 		AssetId:         1,
 		//nonce
 		Nonce:           8,
-		//手续费（最小单位）
-		Fee:             0,
 		Tip:             0,
 		//当前高度
 		BlockHeight:     4571393,
-		//当前高度区块哈希
+		//有效期
+		Period:          64,
+		//era起始高度区块哈希
 		BlockHash:       "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
 		//创世块哈希
 		GenesisHash:     "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
@@ -75,9 +76,8 @@ func Test_json(t *testing.T)  {
 	ts := TxStruct{
 		SenderPubkey:    "123",
 		RecipientPubkey: "",
-		Amount:          0,
+		Amount:          big.NewInt(0),
 		Nonce:           0,
-		Fee:             0,
 		BlockHeight:     0,
 		BlockHash:       "234",
 		GenesisHash:     "345",
@@ -96,4 +96,19 @@ func Test_decode(t *testing.T) {
 
 func Test_Verify(t *testing.T){
 
-}
\ No newline at end of file
+}
+func Test_GetTransactionHash(t *testing.T) {
+	// blake2b-256("abc")
+	excepted := "0xbddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"
+
+	for _, signed := range []string{"616263", "0x616263"} {
+		txid, err := GetTransactionHash(signed)
+		if err != nil || txid != excepted {
+			t.Error("wrong txid : ", txid, err)
+		}
+	}
+
+	if _, err := GetTransactionHash("0x"); err == nil {
+		t.Error("empty transaction not detected")
+	}
+}
@@ -0,0 +1,292 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	signedBit        = byte(0x80)
+	extrinsicVersion = byte(0x04)
+)
+
+// CallArg 解析出的调用参数
+type CallArg struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// SignedTransaction 从签名交易单解析出的内容，TxStruct 中只会填充签名交易里包含的字段
+type SignedTransaction struct {
+	TxStruct
+	Signer    string    `json:"signer"`
+	Signature string    `json:"signature"`
+	Era       string    `json:"era"`
+	Phase     uint64    `json:"phase"`
+	CallIndex string    `json:"call_index"`
+	CallArgs  []CallArg `json:"call_args"`
+
+	//是否为转账或由转账组成的批量转账
+	isTransfer bool
+}
+
+// IsTransfer 交易单的调用是否为转账或由转账组成的批量转账
+func (st *SignedTransaction) IsTransfer() bool {
+	return st.isTransfer
+}
+
+// DecodeSignedTransaction 解析 GetSignedTransaction 生成的签名交易单，transferCode 为转账的 call index，为空时使用默认值
+// batchCodes 为 utility.batch / batch_all 的 call index
+func DecodeSignedTransaction(transferCode, signed string, batchCodes ...string) (*SignedTransaction, error) {
+	if transferCode == "" {
+		transferCode = Generic_Asset_Transfer
+	}
+
+	d, err := NewScaleDecoderFromHex(signed)
+	if err != nil {
+		return nil, errors.New("invalid signed transaction hex")
+	}
+
+	length, err := d.DecodeCompactUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := d.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(body)) != length {
+		return nil, fmt.Errorf("wrong extrinsic length, expect %d, got %d", length, len(body))
+	}
+
+	d = NewScaleDecoderFromBytes(body)
+
+	version, err := d.DecodeU8()
+	if err != nil {
+		return nil, err
+	}
+	if version&signedBit == 0 {
+		return nil, errors.New("extrinsic is not signed")
+	}
+	if version&^signedBit != extrinsicVersion {
+		return nil, fmt.Errorf("unsupported extrinsic version %d", version&^signedBit)
+	}
+
+	st := &SignedTransaction{}
+
+	if AccounntIDFollow {
+		flag, err := d.DecodeU8()
+		if err != nil {
+			return nil, err
+		}
+		if flag != 0xff {
+			return nil, errors.New("invalid signer address")
+		}
+	}
+
+	signer, err := d.DecodeFixedBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	st.Signer = hex.EncodeToString(signer)
+	st.SenderPubkey = st.Signer
+
+	st.SignatureType, err = d.DecodeEnum()
+	if err != nil {
+		return nil, err
+	}
+
+	sigLength := 64
+	switch st.SignatureType {
+	case SignatureTypeEd25519, SignatureTypeSr25519:
+	case SignatureTypeEcdsa:
+		sigLength = 65
+	default:
+		return nil, fmt.Errorf("unknown signature type %d", st.SignatureType)
+	}
+
+	sig, err := d.DecodeFixedBytes(sigLength)
+	if err != nil {
+		return nil, err
+	}
+	st.Signature = hex.EncodeToString(sig)
+
+	era, err := d.DecodeFixedBytes(1)
+	if err != nil {
+		return nil, err
+	}
+	if era[0] != 0 {
+		next, err := d.DecodeFixedBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		era = append(era, next...)
+	}
+	st.Era = hex.EncodeToString(era)
+
+	st.Period, st.Phase, err = DecodeEra(era)
+	if err != nil {
+		return nil, err
+	}
+
+	st.Nonce, err = d.DecodeCompactUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	st.Tip, err = d.DecodeCompactUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	st.FeeExchange, err = decodeFeeExchange(d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = st.decodeCall(transferCode, batchCodes, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (st *SignedTransaction) decodeCall(transferCode string, batchCodes []string, d *ScaleDecoder) error {
+	callIndex, err := d.DecodeFixedBytes(2)
+	if err != nil {
+		return err
+	}
+	st.CallIndex = hex.EncodeToString(callIndex)
+
+	//批量转账中每个调用都是转账，TxStruct.CallIndex 为其中转账的 call index
+	if isBatchCall(st.CallIndex, batchCodes) {
+		st.TxStruct.CallIndex = transferCode
+		return st.decodeBatchCall(transferCode, d)
+	}
+
+	st.TxStruct.CallIndex = st.CallIndex
+
+	if st.CallIndex != transferCode {
+		data, err := d.ReadAll()
+		if err != nil {
+			return err
+		}
+		st.CallArgs = []CallArg{
+			{Name: "data", Type: "Bytes", Value: hex.EncodeToString(data)},
+		}
+		return nil
+	}
+
+	transfer, err := decodeTransferArgs(d)
+	if err != nil {
+		return err
+	}
+
+	if d.HasRemaining() {
+		return errors.New("unexpected bytes after transfer call")
+	}
+
+	st.isTransfer = true
+	st.AssetId = transfer.AssetId
+	st.RecipientPubkey = transfer.RecipientPubkey
+	st.Amount = transfer.Amount
+	st.CallArgs = []CallArg{
+		{Name: "asset_id", Type: "Compact<AssetId>", Value: transfer.AssetId},
+		{Name: "to", Type: "AccountId", Value: transfer.RecipientPubkey},
+		{Name: "amount", Type: "Compact<Balance>", Value: new(big.Int).Set(transfer.Amount)},
+	}
+
+	return nil
+}
+
+// decodeBatchCall 解析 utility.batch，只支持由转账组成的批量调用
+func (st *SignedTransaction) decodeBatchCall(transferCode string, d *ScaleDecoder) error {
+	transfers := make([]TransferItem, 0)
+
+	_, err := d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		callIndex, err := d.DecodeFixedBytes(2)
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(callIndex) != transferCode {
+			return fmt.Errorf("unsupported call %x in batch", callIndex)
+		}
+
+		transfer, err := decodeTransferArgs(d)
+		if err != nil {
+			return err
+		}
+		transfers = append(transfers, *transfer)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if d.HasRemaining() {
+		return errors.New("unexpected bytes after batch call")
+	}
+
+	st.isTransfer = true
+	st.BatchCallIndex = st.CallIndex
+	st.Transfers = transfers
+	st.CallArgs = []CallArg{
+		{Name: "calls", Type: "Vec<Call>", Value: transfers},
+	}
+
+	return nil
+}
+
+func decodeTransferArgs(d *ScaleDecoder) (*TransferItem, error) {
+	if AccounntIDFollow {
+		flag, err := d.DecodeU8()
+		if err != nil {
+			return nil, err
+		}
+		if flag != 0xff {
+			return nil, errors.New("invalid dest address")
+		}
+	}
+
+	assetId, err := d.DecodeCompactUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := d.DecodeFixedBytes(32)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := d.DecodeCompact()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransferItem{
+		RecipientPubkey: hex.EncodeToString(dest),
+		Amount:          amount,
+		AssetId:         assetId,
+	}, nil
+}
+
+func isBatchCall(callIndex string, batchCodes []string) bool {
+	for _, code := range batchCodes {
+		if code != "" && callIndex == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (st SignedTransaction) ToJSONString() string {
+	j, _ := json.Marshal(st)
+
+	return string(j)
+}
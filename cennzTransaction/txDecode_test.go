@@ -0,0 +1,165 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func newDecodeTestTx(amount *big.Int) TxStruct {
+	return TxStruct{
+		SenderPubkey:    "86377c388ec1afc558ef40c5edb3b4f7bba1a697b1bb711ece23fc7cdbfe2e1f",
+		RecipientPubkey: "7dd904f18b1e42c7f2b62429245771f51749a42be06c02bd4251df69f2c141df",
+		Amount:          amount,
+		AssetId:         1,
+		Nonce:           8,
+		Tip:             0,
+		BlockHeight:     4571393,
+		Period:          64,
+		BlockHash:       "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
+		GenesisHash:     "0d0971c150a9741b8719b3c6c9c2e96ec5b2e3fb83641af868e6650f3e263ef0",
+		SpecVersion:     37,
+		TxVersion:       5,
+	}
+}
+
+func signDecodeTestTx(t *testing.T, tx TxStruct) (string, []byte) {
+	emptyTrans, message, err := tx.CreateEmptyTransactionAndMessage()
+	if err != nil {
+		t.Fatal("create failed : ", err)
+	}
+
+	prikey, _ := hex.DecodeString("e86bcaaab0a5aa5e3f3b0885db7e932e34eddb5a620b6bcc097a4b236a5a0354")
+	signature, err := SignTransaction(message, prikey)
+	if err != nil {
+		t.Fatal("sign failed")
+	}
+
+	signedTrans, pass := VerifyAndCombineTransaction(emptyTrans, hex.EncodeToString(signature))
+	if !pass {
+		t.Fatal("verify failed")
+	}
+
+	return signedTrans, signature
+}
+
+func Test_DecodeSignedTransaction(t *testing.T) {
+	tx := newDecodeTestTx(big.NewInt(1303822400))
+
+	signedTrans, signature := signDecodeTestTx(t, tx)
+
+	st, err := DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans)
+	if err != nil {
+		t.Error("decode failed : ", err)
+		return
+	}
+
+	js, _ := json.Marshal(st)
+	fmt.Println("解析结果 ： ", string(js))
+
+	if st.Signer != tx.SenderPubkey || st.SignatureType != SignatureTypeEd25519 || st.Signature != hex.EncodeToString(signature) {
+		t.Error("wrong signer or signature")
+	}
+	if st.Era != "1500" || st.Period != 64 || st.Phase != tx.BlockHeight%64 {
+		t.Error("wrong era : ", st.Era, st.Period, st.Phase)
+	}
+	if st.Nonce != tx.Nonce || st.Tip != tx.Tip || st.FeeExchange != nil {
+		t.Error("wrong nonce, tip or fee exchange")
+	}
+	if st.CallIndex != Generic_Asset_Transfer || st.TxStruct.CallIndex != Generic_Asset_Transfer || !st.IsTransfer() || st.RecipientPubkey != tx.RecipientPubkey || st.Amount.Cmp(tx.Amount) != 0 || st.AssetId != tx.AssetId {
+		t.Error("wrong call : ", st.CallIndex, st.CallArgs)
+	}
+
+	if _, err := DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans[:len(signedTrans)-2]); err == nil {
+		t.Error("truncated transaction not detected")
+	}
+}
+
+func Test_DecodeSignedTransactionU128(t *testing.T) {
+	// 超过 uint64 范围的金额
+	amount, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	tx := newDecodeTestTx(amount)
+
+	signedTrans, _ := signDecodeTestTx(t, tx)
+
+	st, err := DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans)
+	if err != nil {
+		t.Error("decode failed : ", err)
+		return
+	}
+
+	if st.Amount.Cmp(amount) != 0 {
+		t.Error("wrong amount : ", st.Amount.String())
+	}
+}
+
+func Test_DecodeFeeExchangeTransaction(t *testing.T) {
+	tx := newDecodeTestTx(big.NewInt(1303822400))
+	maxPayment, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	tx.FeeExchange = &FeeExchange{AssetId: 1, MaxPayment: maxPayment}
+
+	signedTrans, _ := signDecodeTestTx(t, tx)
+
+	st, err := DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans)
+	if err != nil {
+		t.Error("decode failed : ", err)
+		return
+	}
+
+	if st.FeeExchange == nil || st.FeeExchange.AssetId != 1 || st.FeeExchange.MaxPayment.Cmp(maxPayment) != 0 {
+		t.Error("wrong fee exchange : ", st.FeeExchange)
+	}
+	if st.RecipientPubkey != tx.RecipientPubkey || st.Amount.Cmp(tx.Amount) != 0 {
+		t.Error("wrong call : ", st.CallArgs)
+	}
+
+	tx.FeeExchange = &FeeExchange{AssetId: 1, MaxPayment: big.NewInt(0)}
+	if _, _, err := tx.CreateEmptyTransactionAndMessage(); err == nil {
+		t.Error("zero max payment not detected")
+	}
+}
+
+func Test_DecodeBatchTransaction(t *testing.T) {
+	batchCode := "1a02"
+
+	tx := newDecodeTestTx(nil)
+	tx.BatchCallIndex = batchCode
+	// 8 笔转账，payload 超过 256 字节
+	for i := 0; i < 8; i++ {
+		tx.Transfers = append(tx.Transfers, TransferItem{
+			RecipientPubkey: tx.RecipientPubkey,
+			Amount:          big.NewInt(int64(1000 * (i + 1))),
+			AssetId:         uint64(1 + i%2),
+		})
+	}
+
+	signedTrans, _ := signDecodeTestTx(t, tx)
+
+	st, err := DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans, batchCode)
+	if err != nil {
+		t.Error("decode failed : ", err)
+		return
+	}
+
+	if st.CallIndex != batchCode || st.TxStruct.CallIndex != Generic_Asset_Transfer || !st.IsTransfer() || len(st.Transfers) != len(tx.Transfers) {
+		t.Error("wrong batch call : ", st.CallIndex, len(st.Transfers))
+		return
+	}
+	for i, transfer := range st.Transfers {
+		if transfer.RecipientPubkey != tx.Transfers[i].RecipientPubkey || transfer.Amount.Cmp(tx.Transfers[i].Amount) != 0 || transfer.AssetId != tx.Transfers[i].AssetId {
+			t.Error("wrong transfer ", i, " : ", transfer)
+		}
+	}
+
+	// 不识别 batch 时按未知调用处理
+	st, err = DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans)
+	if err != nil || len(st.Transfers) != 0 || st.CallArgs[0].Name != "data" {
+		t.Error("batch decoded without batch code")
+		return
+	}
+	if st.TxStruct.CallIndex != batchCode || st.IsTransfer() {
+		t.Error("wrong call index of unknown call : ", st.TxStruct.CallIndex)
+	}
+}
@@ -1,34 +1,99 @@
 package cennzTransaction
 
+import (
+	"errors"
+	"math/bits"
+)
 
-const calPeriod = 64
-
-func GetEra(height uint64) []byte {
-	//return []byte{}
-	return []byte{0x0}
-
-	//phase := height % calPeriod
-	//
-	//index := uint64(6)
-	//trailingZero := index - 1
-	//
-	//var encoded uint64
-	//if trailingZero > 1 {
-	//	encoded = trailingZero
-	//} else {
-	//	encoded = 1
-	//}
-	//
-	//if trailingZero < 15 {
-	//	encoded = trailingZero
-	//} else {
-	//	encoded = 15
-	//}
-	//
-	//encoded += phase / 1 << 4
-	//
-	//first := byte(encoded >> 8)
-	//second := byte(encoded & 0xff)
-	//
-	//return []byte{second, first}
+const (
+	minPeriod = 4
+	maxPeriod = 1 << 16
+)
+
+// GetEra 按出生高度和有效期编码交易的 era，period 为 0 时返回永久有效的 era
+func GetEra(height, period uint64) []byte {
+	if period == 0 {
+		return []byte{0x0}
+	}
+
+	period = normalizePeriod(period)
+	quantizeFactor := getQuantizeFactor(period)
+	phase := height % period / quantizeFactor
+
+	trailingZero := uint64(bits.TrailingZeros64(period)) - 1
+	if trailingZero < 1 {
+		trailingZero = 1
+	}
+	if trailingZero > 15 {
+		trailingZero = 15
+	}
+
+	encoded := trailingZero | phase<<4
+
+	return []byte{byte(encoded & 0xff), byte(encoded >> 8)}
+}
+
+// GetEraBirth 返回 era 的起始高度，签名时需要使用该高度的区块哈希
+func GetEraBirth(height, period uint64) uint64 {
+	if period == 0 {
+		return 0
+	}
+
+	period = normalizePeriod(period)
+	quantizeFactor := getQuantizeFactor(period)
+	phase := height % period / quantizeFactor * quantizeFactor
+
+	if height < phase {
+		return phase
+	}
+	return (height-phase)/period*period + phase
+}
+
+// GetEraDeath 返回交易失效的高度，超过该高度的交易会被节点以 outdated 拒绝
+func GetEraDeath(height, period uint64) uint64 {
+	if period == 0 {
+		return ^uint64(0)
+	}
+
+	return GetEraBirth(height, period) + normalizePeriod(period)
+}
+
+// normalizePeriod 将有效期调整为 [4, 65536] 范围内的 2 的幂
+func normalizePeriod(period uint64) uint64 {
+	if period <= minPeriod {
+		return minPeriod
+	}
+	if period >= maxPeriod {
+		return maxPeriod
+	}
+	return 1 << uint(bits.Len64(period-1))
+}
+
+func getQuantizeFactor(period uint64) uint64 {
+	quantizeFactor := period >> 12
+	if quantizeFactor < 1 {
+		quantizeFactor = 1
+	}
+	return quantizeFactor
+}
+
+// DecodeEra 解析 era，返回有效期和相位，永久有效的 era 返回 0, 0
+func DecodeEra(era []byte) (uint64, uint64, error) {
+	if len(era) == 1 && era[0] == 0 {
+		return 0, 0, nil
+	}
+	if len(era) != 2 {
+		return 0, 0, errors.New("invalid era")
+	}
+
+	encoded := uint64(era[0]) | uint64(era[1])<<8
+	period := uint64(2) << (encoded % (1 << 4))
+	quantizeFactor := getQuantizeFactor(period)
+	phase := (encoded >> 4) * quantizeFactor
+
+	if period < minPeriod || phase >= period {
+		return 0, 0, errors.New("invalid era")
+	}
+
+	return period, phase, nil
 }
@@ -9,7 +9,63 @@ import (
 func TestGetEra(t *testing.T) {
 	height := uint64(0)
 
-	era := GetEra(height)
+	era := GetEra(height, 0)
 
 	fmt.Println(hex.EncodeToString(era))
+
+	testTable := []struct {
+		height   uint64
+		period   uint64
+		excepted string
+	}{
+		{42, 0, "00"},
+		{42, 64, "a502"},
+		{4571393, 64, "1500"},
+		{4571393, Default_Period, "1500"},
+		{20000, 32768, "4e9c"},
+	}
+
+	for _, item := range testTable {
+		era := hex.EncodeToString(GetEra(item.height, item.period))
+		if era != item.excepted {
+			t.Error(item.height, item.period, " failed, got ", era)
+		}
+	}
+}
+
+func TestGetEraBirthAndDeath(t *testing.T) {
+	if birth := GetEraBirth(4571393, 64); birth != 4571393 {
+		t.Error("wrong birth ", birth)
+	}
+
+	if death := GetEraDeath(4571393, 64); death != 4571393+64 {
+		t.Error("wrong death ", death)
+	}
+
+	if birth := GetEraBirth(20001, 32768); birth != 20000 {
+		t.Error("wrong quantized birth ", birth)
+	}
+}
+
+func TestDecodeEra(t *testing.T) {
+	testTable := []struct {
+		height uint64
+		period uint64
+	}{
+		{42, 0},
+		{42, 64},
+		{4571393, 64},
+		{20000, 32768},
+	}
+
+	for _, item := range testTable {
+		period, phase, err := DecodeEra(GetEra(item.height, item.period))
+		if err != nil {
+			t.Error(item.height, item.period, " decode failed : ", err)
+			continue
+		}
+		if period != item.period || (period > 0 && phase != GetEraBirth(item.height, item.period)%period) {
+			t.Error(item.height, item.period, " decode failed, got ", period, phase)
+		}
+	}
 }
@@ -0,0 +1,64 @@
+package cennzTransaction
+
+import (
+	"errors"
+	"math/big"
+)
+
+// FeeExchange ChargeTransactionPayment 中的手续费兑换参数，通过 CENNZX 使用其他资产支付手续费
+type FeeExchange struct {
+	AssetId    uint64   `json:"assetId"`
+	MaxPayment *big.Int `json:"maxPayment"`
+}
+
+const feeExchangeV1 = 0
+
+// encodeFeeExchange 编码 Option<FeeExchange>，为 nil 时使用 CPAY 支付手续费
+func encodeFeeExchange(e *ScaleEncoder, feeExchange *FeeExchange) error {
+	return e.EncodeOption(feeExchange != nil, func(e *ScaleEncoder) error {
+		if feeExchange.MaxPayment == nil || feeExchange.MaxPayment.Sign() <= 0 {
+			return errors.New("fee exchange max payment must be positive")
+		}
+		return e.EncodeEnum(feeExchangeV1, func(e *ScaleEncoder) error {
+			if err := e.EncodeCompact(feeExchange.AssetId); err != nil {
+				return err
+			}
+			return e.EncodeCompactBigInt(feeExchange.MaxPayment)
+		})
+	})
+}
+
+// decodeFeeExchange 解析 Option<FeeExchange>
+func decodeFeeExchange(d *ScaleDecoder) (*FeeExchange, error) {
+	var feeExchange *FeeExchange
+
+	_, err := d.DecodeOption(func(d *ScaleDecoder) error {
+		version, err := d.DecodeEnum()
+		if err != nil {
+			return err
+		}
+		if version != feeExchangeV1 {
+			return errors.New("unsupported fee exchange version")
+		}
+
+		assetId, err := d.DecodeCompactUint64()
+		if err != nil {
+			return err
+		}
+		maxPayment, err := d.DecodeCompact()
+		if err != nil {
+			return err
+		}
+
+		feeExchange = &FeeExchange{
+			AssetId:    assetId,
+			MaxPayment: maxPayment,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return feeExchange, nil
+}
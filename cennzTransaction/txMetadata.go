@@ -0,0 +1,472 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 元数据前缀 "meta"
+const metadataMagic = uint32(0x6174656d)
+
+// 支持解析的元数据版本
+const (
+	MetadataV11 = 11
+	MetadataV12 = 12
+	MetadataV13 = 13
+)
+
+// 存储项类型
+const (
+	StorageTypePlain     = 0
+	StorageTypeMap       = 1
+	StorageTypeDoubleMap = 2
+	StorageTypeNMap      = 3
+)
+
+// 存储键的哈希方式
+const (
+	StorageHasherBlake2_128       = 0
+	StorageHasherBlake2_256       = 1
+	StorageHasherBlake2_128Concat = 2
+	StorageHasherTwox128          = 3
+	StorageHasherTwox256          = 4
+	StorageHasherTwox64Concat     = 5
+	StorageHasherIdentity         = 6
+)
+
+// RuntimeMetadata 解析后的运行时元数据，只保留按名称查找序号所需的内容
+type RuntimeMetadata struct {
+	Version uint8             `json:"version"`
+	Modules []*MetadataModule `json:"modules"`
+}
+
+type MetadataModule struct {
+	Name  string `json:"name"`
+	Index uint8  `json:"index"`
+	// V11 没有显式的序号，调用和事件按各自出现的顺序编号
+	CallIndex  uint8               `json:"call_index"`
+	EventIndex uint8               `json:"event_index"`
+	Storage    *MetadataStorage    `json:"storage"`
+	Calls      []*MetadataCall     `json:"calls"`
+	Events     []*MetadataEvent    `json:"events"`
+	Errors     []string            `json:"errors"`
+	Constants  []*MetadataConstant `json:"constants"`
+}
+
+type MetadataStorage struct {
+	Prefix  string                  `json:"prefix"`
+	Entries []*MetadataStorageEntry `json:"entries"`
+}
+
+type MetadataStorageEntry struct {
+	Name     string   `json:"name"`
+	Modifier uint8    `json:"modifier"`
+	Type     uint8    `json:"type"`
+	Hashers  []uint8  `json:"hashers"`
+	Keys     []string `json:"keys"`
+	Value    string   `json:"value"`
+	Default  []byte   `json:"default"`
+}
+
+type MetadataCall struct {
+	Name string     `json:"name"`
+	Args []*CallArg `json:"args"`
+}
+
+type MetadataEvent struct {
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+}
+
+type MetadataConstant struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value []byte `json:"value"`
+}
+
+// DecodeRuntimeMetadata 解析 state_getMetadata 返回的元数据，支持 V11 - V13
+func DecodeRuntimeMetadata(metadataHex string) (*RuntimeMetadata, error) {
+	d, err := NewScaleDecoderFromHex(metadataHex)
+	if err != nil {
+		return nil, errors.New("invalid metadata hex")
+	}
+
+	magic, err := d.DecodeU32()
+	if err != nil {
+		return nil, err
+	}
+	if magic != metadataMagic {
+		return nil, errors.New("invalid metadata magic number")
+	}
+
+	version, err := d.DecodeEnum()
+	if err != nil {
+		return nil, err
+	}
+	if version < MetadataV11 || version > MetadataV13 {
+		return nil, fmt.Errorf("unsupported metadata version %d", version)
+	}
+
+	md := &RuntimeMetadata{Version: version}
+
+	var callIndex, eventIndex uint8
+	_, err = d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		module, err := decodeMetadataModule(version, d)
+		if err != nil {
+			return err
+		}
+
+		if version == MetadataV11 {
+			module.Index = uint8(i)
+			module.CallIndex = callIndex
+			module.EventIndex = eventIndex
+			if module.Calls != nil {
+				callIndex++
+			}
+			if module.Events != nil {
+				eventIndex++
+			}
+		} else {
+			module.CallIndex = module.Index
+			module.EventIndex = module.Index
+		}
+
+		md.Modules = append(md.Modules, module)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return md, nil
+}
+
+func decodeMetadataModule(version uint8, d *ScaleDecoder) (*MetadataModule, error) {
+	var err error
+	module := &MetadataModule{}
+
+	module.Name, err = d.DecodeString()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.DecodeOption(func(d *ScaleDecoder) error {
+		module.Storage, err = decodeMetadataStorage(d)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.DecodeOption(func(d *ScaleDecoder) error {
+		module.Calls = make([]*MetadataCall, 0)
+		_, err := d.DecodeVec(func(i int, d *ScaleDecoder) error {
+			call := &MetadataCall{}
+			call.Name, err = d.DecodeString()
+			if err != nil {
+				return err
+			}
+			_, err = d.DecodeVec(func(i int, d *ScaleDecoder) error {
+				arg := &CallArg{}
+				arg.Name, err = d.DecodeString()
+				if err != nil {
+					return err
+				}
+				arg.Type, err = d.DecodeString()
+				if err != nil {
+					return err
+				}
+				call.Args = append(call.Args, arg)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			_, err = decodeStringVec(d)
+			if err != nil {
+				return err
+			}
+			module.Calls = append(module.Calls, call)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.DecodeOption(func(d *ScaleDecoder) error {
+		module.Events = make([]*MetadataEvent, 0)
+		_, err := d.DecodeVec(func(i int, d *ScaleDecoder) error {
+			event := &MetadataEvent{}
+			event.Name, err = d.DecodeString()
+			if err != nil {
+				return err
+			}
+			event.Args, err = decodeStringVec(d)
+			if err != nil {
+				return err
+			}
+			_, err = decodeStringVec(d)
+			if err != nil {
+				return err
+			}
+			module.Events = append(module.Events, event)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		constant := &MetadataConstant{}
+		constant.Name, err = d.DecodeString()
+		if err != nil {
+			return err
+		}
+		constant.Type, err = d.DecodeString()
+		if err != nil {
+			return err
+		}
+		constant.Value, err = d.DecodeBytes()
+		if err != nil {
+			return err
+		}
+		_, err = decodeStringVec(d)
+		if err != nil {
+			return err
+		}
+		module.Constants = append(module.Constants, constant)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		name, err := d.DecodeString()
+		if err != nil {
+			return err
+		}
+		_, err = decodeStringVec(d)
+		if err != nil {
+			return err
+		}
+		module.Errors = append(module.Errors, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if version >= MetadataV12 {
+		module.Index, err = d.DecodeU8()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return module, nil
+}
+
+func decodeMetadataStorage(d *ScaleDecoder) (*MetadataStorage, error) {
+	var err error
+	storage := &MetadataStorage{}
+
+	storage.Prefix, err = d.DecodeString()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		entry := &MetadataStorageEntry{}
+		entry.Name, err = d.DecodeString()
+		if err != nil {
+			return err
+		}
+		entry.Modifier, err = d.DecodeU8()
+		if err != nil {
+			return err
+		}
+		entry.Type, err = d.DecodeEnum()
+		if err != nil {
+			return err
+		}
+
+		switch entry.Type {
+		case StorageTypePlain:
+			entry.Value, err = d.DecodeString()
+		case StorageTypeMap:
+			err = decodeStorageMap(entry, d)
+		case StorageTypeDoubleMap:
+			err = decodeStorageDoubleMap(entry, d)
+		case StorageTypeNMap:
+			err = decodeStorageNMap(entry, d)
+		default:
+			err = fmt.Errorf("unknown storage entry type %d", entry.Type)
+		}
+		if err != nil {
+			return err
+		}
+
+		entry.Default, err = d.DecodeBytes()
+		if err != nil {
+			return err
+		}
+		_, err = decodeStringVec(d)
+		if err != nil {
+			return err
+		}
+
+		storage.Entries = append(storage.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+func decodeStorageMap(entry *MetadataStorageEntry, d *ScaleDecoder) error {
+	hasher, err := d.DecodeEnum()
+	if err != nil {
+		return err
+	}
+	key, err := d.DecodeString()
+	if err != nil {
+		return err
+	}
+	entry.Value, err = d.DecodeString()
+	if err != nil {
+		return err
+	}
+	// unused
+	_, err = d.DecodeBool()
+	if err != nil {
+		return err
+	}
+
+	entry.Hashers = []uint8{hasher}
+	entry.Keys = []string{key}
+	return nil
+}
+
+func decodeStorageDoubleMap(entry *MetadataStorageEntry, d *ScaleDecoder) error {
+	hasher, err := d.DecodeEnum()
+	if err != nil {
+		return err
+	}
+	key1, err := d.DecodeString()
+	if err != nil {
+		return err
+	}
+	key2, err := d.DecodeString()
+	if err != nil {
+		return err
+	}
+	entry.Value, err = d.DecodeString()
+	if err != nil {
+		return err
+	}
+	key2Hasher, err := d.DecodeEnum()
+	if err != nil {
+		return err
+	}
+
+	entry.Hashers = []uint8{hasher, key2Hasher}
+	entry.Keys = []string{key1, key2}
+	return nil
+}
+
+func decodeStorageNMap(entry *MetadataStorageEntry, d *ScaleDecoder) error {
+	var err error
+	entry.Keys, err = decodeStringVec(d)
+	if err != nil {
+		return err
+	}
+	_, err = d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		hasher, err := d.DecodeEnum()
+		if err != nil {
+			return err
+		}
+		entry.Hashers = append(entry.Hashers, hasher)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	entry.Value, err = d.DecodeString()
+	return err
+}
+
+func decodeStringVec(d *ScaleDecoder) ([]string, error) {
+	ret := make([]string, 0)
+	_, err := d.DecodeVec(func(i int, d *ScaleDecoder) error {
+		s, err := d.DecodeString()
+		if err != nil {
+			return err
+		}
+		ret = append(ret, s)
+		return nil
+	})
+	return ret, err
+}
+
+// FindModule 按名称查找模块，忽略大小写，genericAsset 与 GenericAsset 视为相同
+func (md *RuntimeMetadata) FindModule(name string) (*MetadataModule, error) {
+	for _, module := range md.Modules {
+		if strings.EqualFold(module.Name, name) {
+			return module, nil
+		}
+	}
+	return nil, fmt.Errorf("module %s not found in metadata", name)
+}
+
+// FindCallIndex 按名称查找调用序号，返回 2 字节的十六进制字符串，如 0401
+func (md *RuntimeMetadata) FindCallIndex(moduleName, callName string) (string, error) {
+	module, err := md.FindModule(moduleName)
+	if err != nil {
+		return "", err
+	}
+
+	for i, call := range module.Calls {
+		if strings.EqualFold(call.Name, callName) {
+			return hex.EncodeToString([]byte{module.CallIndex, byte(i)}), nil
+		}
+	}
+	return "", fmt.Errorf("call %s.%s not found in metadata", moduleName, callName)
+}
+
+// FindEventIndex 按名称查找事件序号，返回 2 字节的十六进制字符串，如 0401
+func (md *RuntimeMetadata) FindEventIndex(moduleName, eventName string) (string, error) {
+	module, err := md.FindModule(moduleName)
+	if err != nil {
+		return "", err
+	}
+
+	for i, event := range module.Events {
+		if strings.EqualFold(event.Name, eventName) {
+			return hex.EncodeToString([]byte{module.EventIndex, byte(i)}), nil
+		}
+	}
+	return "", fmt.Errorf("event %s.%s not found in metadata", moduleName, eventName)
+}
+
+// FindError 按 DispatchError::Module 的模块序号和错误序号查找错误名称
+func (md *RuntimeMetadata) FindError(moduleIndex, errorIndex uint8) (string, string, error) {
+	for _, module := range md.Modules {
+		if module.Index != moduleIndex {
+			continue
+		}
+		if int(errorIndex) >= len(module.Errors) {
+			return module.Name, "", fmt.Errorf("error %d not found in module %s", errorIndex, module.Name)
+		}
+		return module.Name, module.Errors[errorIndex], nil
+	}
+	return "", "", fmt.Errorf("module %d not found in metadata", moduleIndex)
+}
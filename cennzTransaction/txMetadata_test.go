@@ -0,0 +1,176 @@
+package cennzTransaction
+
+import (
+	"testing"
+)
+
+type testModule struct {
+	name    string
+	index   uint8
+	storage bool
+	calls   []string
+	events  []string
+}
+
+func encodeTestStrings(e *ScaleEncoder, list []string) {
+	e.EncodeVec(len(list), func(i int, e *ScaleEncoder) error {
+		e.EncodeString(list[i])
+		return nil
+	})
+}
+
+func encodeTestMetadata(version uint8, modules []testModule) string {
+	e := NewScaleEncoder()
+	e.EncodeU32(metadataMagic)
+	e.EncodeU8(version)
+
+	e.EncodeVec(len(modules), func(i int, e *ScaleEncoder) error {
+		m := modules[i]
+		e.EncodeString(m.name)
+
+		e.EncodeOption(m.storage, func(e *ScaleEncoder) error {
+			e.EncodeString(m.name)
+			e.EncodeCompact(2)
+			// Plain
+			e.EncodeString("Number")
+			e.EncodeU8(1)
+			e.EncodeEnum(StorageTypePlain, nil)
+			e.EncodeString("BlockNumber")
+			e.EncodeBytes([]byte{0, 0, 0, 0})
+			encodeTestStrings(e, []string{"doc"})
+			// DoubleMap
+			e.EncodeString("FreeBalance")
+			e.EncodeU8(1)
+			e.EncodeEnum(StorageTypeDoubleMap, nil)
+			e.EncodeU8(StorageHasherTwox64Concat)
+			e.EncodeString("AssetId")
+			e.EncodeString("AccountId")
+			e.EncodeString("Balance")
+			e.EncodeU8(StorageHasherBlake2_128Concat)
+			e.EncodeBytes(make([]byte, 16))
+			encodeTestStrings(e, nil)
+			return nil
+		})
+
+		e.EncodeOption(m.calls != nil, func(e *ScaleEncoder) error {
+			return e.EncodeVec(len(m.calls), func(i int, e *ScaleEncoder) error {
+				e.EncodeString(m.calls[i])
+				e.EncodeVec(1, func(i int, e *ScaleEncoder) error {
+					e.EncodeString("amount")
+					e.EncodeString("Compact<Balance>")
+					return nil
+				})
+				encodeTestStrings(e, []string{"call doc"})
+				return nil
+			})
+		})
+
+		e.EncodeOption(m.events != nil, func(e *ScaleEncoder) error {
+			return e.EncodeVec(len(m.events), func(i int, e *ScaleEncoder) error {
+				e.EncodeString(m.events[i])
+				encodeTestStrings(e, []string{"AccountId", "Balance"})
+				encodeTestStrings(e, nil)
+				return nil
+			})
+		})
+
+		// constants
+		e.EncodeVec(1, func(i int, e *ScaleEncoder) error {
+			e.EncodeString("Const")
+			e.EncodeString("u32")
+			e.EncodeBytes([]byte{1, 0, 0, 0})
+			encodeTestStrings(e, nil)
+			return nil
+		})
+
+		// errors
+		e.EncodeVec(1, func(i int, e *ScaleEncoder) error {
+			e.EncodeString("SomeError")
+			encodeTestStrings(e, nil)
+			return nil
+		})
+
+		if version >= MetadataV12 {
+			e.EncodeU8(m.index)
+		}
+		return nil
+	})
+
+	// extrinsic
+	e.EncodeU8(4)
+	encodeTestStrings(e, []string{"CheckVersion"})
+
+	return e.Hex()
+}
+
+var testMetadataModules = []testModule{
+	{name: "System", index: 0, storage: true, calls: []string{"remark"}, events: []string{"ExtrinsicSuccess", "ExtrinsicFailed"}},
+	{name: "Timestamp", index: 1, storage: true, calls: []string{"set"}},
+	{name: "Offences", index: 2, events: []string{"Offence"}},
+	{name: "Session", index: 3},
+	{name: "GenericAsset", index: 6, storage: true, calls: []string{"burn", "transfer"}, events: []string{"Created", "Transferred"}},
+}
+
+func Test_DecodeRuntimeMetadata(t *testing.T) {
+	testTable := []struct {
+		version  uint8
+		transfer string
+		success  string
+		event    string
+	}{
+		// V11 按照有调用/事件的模块顺序编号
+		{MetadataV11, "0201", "0000", "0201"},
+		{MetadataV12, "0601", "0000", "0601"},
+		{MetadataV13, "0601", "0000", "0601"},
+	}
+
+	for _, item := range testTable {
+		md, err := DecodeRuntimeMetadata(encodeTestMetadata(item.version, testMetadataModules))
+		if err != nil {
+			t.Error(item.version, " decode failed : ", err)
+			continue
+		}
+
+		transfer, err := md.FindCallIndex("genericAsset", "transfer")
+		if err != nil || transfer != item.transfer {
+			t.Error(item.version, " wrong transfer call index : ", transfer, err)
+		}
+
+		success, err := md.FindEventIndex("system", "ExtrinsicSuccess")
+		if err != nil || success != item.success {
+			t.Error(item.version, " wrong success event index : ", success, err)
+		}
+
+		event, err := md.FindEventIndex("genericAsset", "Transferred")
+		if err != nil || event != item.event {
+			t.Error(item.version, " wrong transferred event index : ", event, err)
+		}
+
+		module, _ := md.FindModule("GenericAsset")
+		entry := module.Storage.Entries[1]
+		if entry.Name != "FreeBalance" || len(entry.Hashers) != 2 || entry.Hashers[1] != StorageHasherBlake2_128Concat {
+			t.Error(item.version, " wrong storage entry : ", entry)
+		}
+	}
+
+	md, _ := DecodeRuntimeMetadata(encodeTestMetadata(MetadataV12, testMetadataModules))
+	if _, err := md.FindCallIndex("genericAsset", "mint"); err == nil {
+		t.Error("unknown call found")
+	}
+
+	// V12 错误按模块的显式序号查找，V11 按模块顺序
+	if module, name, err := md.FindError(6, 0); err != nil || module != "GenericAsset" || name != "SomeError" {
+		t.Error("wrong module error : ", module, name, err)
+	}
+	if _, _, err := md.FindError(6, 1); err == nil {
+		t.Error("unknown error found")
+	}
+	md11, _ := DecodeRuntimeMetadata(encodeTestMetadata(MetadataV11, testMetadataModules))
+	if module, _, err := md11.FindError(4, 0); err != nil || module != "GenericAsset" {
+		t.Error("wrong V11 module error : ", module, err)
+	}
+
+	if _, err := DecodeRuntimeMetadata(encodeTestMetadata(10, testMetadataModules)); err == nil {
+		t.Error("unsupported version not detected")
+	}
+}
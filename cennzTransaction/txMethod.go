@@ -3,6 +3,7 @@ package cennzTransaction
 import (
 	"encoding/hex"
 	"errors"
+	"math/big"
 )
 
 type MethodTransfer struct {
@@ -11,29 +12,30 @@ type MethodTransfer struct {
 	AssetId    []byte
 }
 
-func NewMethodTransfer(pubkey string, amount, assetId uint64) (*MethodTransfer, error) {
+func NewMethodTransfer(pubkey string, amount *big.Int, assetId uint64) (*MethodTransfer, error) {
 	pubBytes, err := hex.DecodeString(pubkey)
 	if  err != nil || len(pubBytes) != 32 {
 		return nil, errors.New("invalid dest public key")
 	}
 
-	if amount == 0 {
+	if amount == nil || amount.Sign() == 0 {
 		return nil, errors.New("zero amount")
 	}
-	amountStr := Encode( uint64(amount) )
+	//Balance 为 u128，使用大数编码
+	e := NewScaleEncoder()
+	err = e.EncodeCompactBigInt(amount)
 	if err != nil {
 		return nil, errors.New("invalid amount")
 	}
-	amountBytes, _ := hex.DecodeString(amountStr)
+	amountBytes := e.Bytes()
 
 	if assetId == 0 {
 		return nil, errors.New("zero assetId")
 	}
-	assetIdStr := Encode( uint64(assetId) )
+	assetIdBytes, err := CompactBytes(assetId)
 	if err != nil {
 		return nil, errors.New("invalid assetId")
 	}
-	assetIdBytes, _ := hex.DecodeString(assetIdStr)
 
 	return &MethodTransfer{
 		DestPubkey: pubBytes,
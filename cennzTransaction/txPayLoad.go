@@ -1,6 +1,12 @@
 package cennzTransaction
 
-import "encoding/hex"
+import (
+	"encoding/hex"
+	"github.com/blocktree/go-owcrypt"
+)
+
+// 待签名的 payload 超过该长度时，签名的消息为 payload 的 blake2b-256 哈希
+const maxUnhashedPayloadLength = 256
 
 type TxPayLoad struct {
 	Method []byte
@@ -14,7 +20,7 @@ type TxPayLoad struct {
 	TxVersion []byte
 }
 
-func (t TxPayLoad) ToBytesString () string {
+func (t TxPayLoad) ToBytes () []byte {
 	payload := make([]byte, 0)
 
 	//payload = append(payload, SigningBitV4)
@@ -28,5 +34,16 @@ func (t TxPayLoad) ToBytesString () string {
 	payload = append(payload, t.GenesisHash...)
 	payload = append(payload, t.BlockHash...)
 
+	return payload
+}
+
+// ToBytesString 返回待签消息，签名和验签都使用该消息
+func (t TxPayLoad) ToBytesString () string {
+	payload := t.ToBytes()
+
+	if len(payload) > maxUnhashedPayloadLength {
+		payload = owcrypt.Hash(payload, 32, owcrypt.HASH_ALG_BLAKE2B)
+	}
+
 	return hex.EncodeToString(payload)
-}
\ No newline at end of file
+}
@@ -0,0 +1,38 @@
+package cennzTransaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+func Test_PayLoadMessage(t *testing.T) {
+	tp := TxPayLoad{
+		Era:         []byte{0x15, 0x00},
+		Nonce:       []byte{0x20},
+		Tip:         []byte{0x00},
+		Fee:         []byte{0x00},
+		SpecVersion: []byte{0x25, 0x00, 0x00, 0x00},
+		TxVersion:   []byte{0x05, 0x00, 0x00, 0x00},
+		GenesisHash: make([]byte, 32),
+		BlockHash:   make([]byte, 32),
+	}
+
+	// 长度为 256 字节，直接签名 payload
+	tp.Method = bytes.Repeat([]byte{0x01}, maxUnhashedPayloadLength-len(tp.ToBytes()))
+	if len(tp.ToBytes()) != maxUnhashedPayloadLength {
+		t.Fatal("wrong payload length ", len(tp.ToBytes()))
+	}
+	if tp.ToBytesString() != hex.EncodeToString(tp.ToBytes()) {
+		t.Error("payload of 256 bytes should not be hashed")
+	}
+
+	// 超过 256 字节，签名 payload 的 blake2b-256 哈希
+	tp.Method = append(tp.Method, 0x01)
+	excepted := hex.EncodeToString(owcrypt.Hash(tp.ToBytes(), 32, owcrypt.HASH_ALG_BLAKE2B))
+	if tp.ToBytesString() != excepted {
+		t.Error("long payload should be hashed, got ", tp.ToBytesString())
+	}
+}
@@ -19,8 +19,3 @@ const  (
 	twoByteModeMaxValue  = 16383
 	fourByteModeMaxValue = 1073741823
 )
-var modeToNumOfBytes = map[byte]uint{
-	singleMode:   1,
-	twoByteMode:  2,
-	fourByteMode: 4,
-}
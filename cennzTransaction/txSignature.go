@@ -0,0 +1,109 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/ChainSafe/go-schnorrkel"
+	"github.com/blocktree/go-owcrypt"
+)
+
+// 签名类型，对应 MultiSignature 的枚举序号
+const (
+	SignatureTypeEd25519 = byte(0x00)
+	SignatureTypeSr25519 = byte(0x01)
+	SignatureTypeEcdsa   = byte(0x02)
+)
+
+// substrate 中 sr25519 的签名上下文
+var sr25519SigningContext = []byte("substrate")
+
+// GetPublicKey 根据私钥计算公钥，sr25519 的私钥标量由 HD 派生的私钥加上偏移量得到，见 GetSr25519PublicKey
+func GetPublicKey(prikey []byte, signatureType byte) ([]byte, error) {
+	if prikey == nil || len(prikey) != 32 {
+		return nil, errors.New("invalid private key")
+	}
+
+	switch signatureType {
+	case SignatureTypeEd25519:
+		pubkey, retCode := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_ED25519)
+		if retCode != owcrypt.SUCCESS {
+			return nil, errors.New("invalid private key")
+		}
+		return pubkey, nil
+	case SignatureTypeSr25519:
+		secretKey, err := newSr25519SecretKey(prikey)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := secretKey.Public()
+		if err != nil {
+			return nil, errors.New("invalid private key")
+		}
+		pubkey := pub.Encode()
+		return pubkey[:], nil
+	}
+
+	return nil, errors.New("unsupported signature type")
+}
+
+// SignTransactionWithType 按签名类型对待签消息签名
+func SignTransactionWithType(msgStr string, prikey []byte, signatureType byte) ([]byte, error) {
+	switch signatureType {
+	case SignatureTypeEd25519:
+		return SignTransaction(msgStr, prikey)
+	case SignatureTypeSr25519:
+	default:
+		return nil, errors.New("unsupported signature type")
+	}
+
+	msg, err := hex.DecodeString(msgStr)
+	if err != nil || len(msg) == 0 {
+		return nil, errors.New("invalid message to sign")
+	}
+
+	secretKey, err := newSr25519SecretKey(prikey)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := secretKey.Sign(schnorrkel.NewSigningContext(sr25519SigningContext, msg))
+	if err != nil {
+		return nil, errors.New("sign failed")
+	}
+
+	signature := sig.Encode()
+	return signature[:], nil
+}
+
+// VerifySignature 按签名类型验证签名
+func VerifySignature(pubkey, msg, signature []byte, signatureType byte) bool {
+	if len(pubkey) != 32 || len(signature) != 64 {
+		return false
+	}
+
+	switch signatureType {
+	case SignatureTypeEd25519:
+		return owcrypt.SUCCESS == owcrypt.Verify(pubkey, nil, msg, signature, owcrypt.ECC_CURVE_ED25519)
+	case SignatureTypeSr25519:
+		var (
+			pubBytes [schnorrkel.PublicKeySize]byte
+			sigBytes [schnorrkel.SignatureSize]byte
+		)
+		copy(pubBytes[:], pubkey)
+		copy(sigBytes[:], signature)
+
+		pub, err := schnorrkel.NewPublicKey(pubBytes)
+		if err != nil {
+			return false
+		}
+		sig := &schnorrkel.Signature{}
+		if err := sig.Decode(sigBytes); err != nil {
+			return false
+		}
+		ok, err := pub.Verify(sig, schnorrkel.NewSigningContext(sr25519SigningContext, msg))
+		return err == nil && ok
+	}
+
+	return false
+}
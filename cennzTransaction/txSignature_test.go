@@ -0,0 +1,104 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt/eddsa/edwards25519"
+)
+
+func Test_Sr25519PublicKey(t *testing.T) {
+	prikeys := []string{
+		"e5be9a5092b81bca64be81d212e7f2f9eba183bb7a90954f7b76361f6edb5c0a",
+		"e86bcaaab0a5aa5e3f3b0885db7e932e34eddb5a620b6bcc097a4b236a5a0354",
+		"0100000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	for _, k := range prikeys {
+		prikey, _ := hex.DecodeString(k)
+
+		pubkey, err := GetPublicKey(prikey, SignatureTypeSr25519)
+		if err != nil {
+			t.Error("get public key failed : ", err)
+			return
+		}
+
+		// 只通过 ed25519 公钥得到的 sr25519 公钥需要与私钥计算的一致
+		edPubkey, _ := GetPublicKey(prikey, SignatureTypeEd25519)
+		converted, err := GetSr25519PublicKey(edPubkey)
+		if err != nil {
+			t.Error("convert public key failed : ", err)
+			return
+		}
+
+		if hex.EncodeToString(pubkey) != hex.EncodeToString(converted) {
+			t.Error("wrong sr25519 public key : ", hex.EncodeToString(pubkey), hex.EncodeToString(converted))
+		}
+	}
+
+	// ed25519 基点按 ristretto255 编码
+	var (
+		basePoint edwards25519.ExtendedGroupElement
+		one       [32]byte
+	)
+	one[0] = 1
+	edwards25519.GeScalarMultBase(&basePoint, &one)
+	if encoded := ristrettoEncode(&basePoint); hex.EncodeToString(encoded[:]) != "e2f2ae0a6abc4e71a884a961c500515f58e30b6aa582dd8db6a65945e08d2d76" {
+		t.Error("wrong ristretto255 base point : ", hex.EncodeToString(encoded[:]))
+	}
+
+	// sr25519 不使用 ed25519 的私钥标量，私钥为 1 时公钥不是基点
+	prikey, _ := hex.DecodeString(prikeys[2])
+	pubkey, _ := GetPublicKey(prikey, SignatureTypeSr25519)
+	if hex.EncodeToString(pubkey) == "e2f2ae0a6abc4e71a884a961c500515f58e30b6aa582dd8db6a65945e08d2d76" {
+		t.Error("sr25519 reuses the ed25519 private scalar")
+	}
+
+	if _, err := GetSr25519PublicKey(make([]byte, 31)); err == nil {
+		t.Error("invalid public key not detected")
+	}
+}
+
+func Test_Sr25519Transaction(t *testing.T) {
+	prikey, _ := hex.DecodeString("e5be9a5092b81bca64be81d212e7f2f9eba183bb7a90954f7b76361f6edb5c0a")
+	pubkey, _ := GetPublicKey(prikey, SignatureTypeSr25519)
+
+	tx := newDecodeTestTx(big.NewInt(1303822400))
+	tx.SenderPubkey = hex.EncodeToString(pubkey)
+	tx.SignatureType = SignatureTypeSr25519
+
+	emptyTrans, message, err := tx.CreateEmptyTransactionAndMessage()
+	if err != nil {
+		t.Error("create failed : ", err)
+		return
+	}
+
+	signature, err := SignTransactionWithType(message, prikey, SignatureTypeSr25519)
+	if err != nil {
+		t.Error("sign failed : ", err)
+		return
+	}
+
+	// ed25519 验签不能通过 sr25519 签名
+	msg, _ := hex.DecodeString(message)
+	if VerifySignature(pubkey, msg, signature, SignatureTypeEd25519) {
+		t.Error("sr25519 signature passed ed25519 verify")
+	}
+
+	signedTrans, pass := VerifyAndCombineTransaction(emptyTrans, hex.EncodeToString(signature))
+	if !pass {
+		t.Error("verify failed")
+		return
+	}
+
+	st, err := DecodeSignedTransaction(Generic_Asset_Transfer, signedTrans)
+	if err != nil {
+		t.Error("decode failed : ", err)
+		return
+	}
+
+	if st.SignatureType != SignatureTypeSr25519 || st.Signer != tx.SenderPubkey {
+		t.Error("wrong signature type : ", st.SignatureType)
+	}
+}
@@ -0,0 +1,108 @@
+package cennzTransaction
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+// StorageHash 按存储键的哈希方式计算哈希，Concat 类型会在哈希后拼接原始数据
+func StorageHash(hasher uint8, data []byte) ([]byte, error) {
+	switch hasher {
+	case StorageHasherBlake2_128:
+		return owcrypt.Hash(data, 16, owcrypt.HASH_ALG_BLAKE2B), nil
+	case StorageHasherBlake2_256:
+		return owcrypt.Hash(data, 32, owcrypt.HASH_ALG_BLAKE2B), nil
+	case StorageHasherBlake2_128Concat:
+		return append(owcrypt.Hash(data, 16, owcrypt.HASH_ALG_BLAKE2B), data...), nil
+	case StorageHasherTwox128:
+		return Twox(data, 16), nil
+	case StorageHasherTwox256:
+		return Twox(data, 32), nil
+	case StorageHasherTwox64Concat:
+		return append(Twox(data, 8), data...), nil
+	case StorageHasherIdentity:
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown storage hasher %d", hasher)
+}
+
+// CreateStorageKey 按元数据生成存储键，keys 为 SCALE 编码后的各个键，返回 0x 开头的十六进制
+func (md *RuntimeMetadata) CreateStorageKey(moduleName, entryName string, keys ...[]byte) (string, error) {
+	module, err := md.FindModule(moduleName)
+	if err != nil {
+		return "", err
+	}
+	if module.Storage == nil {
+		return "", fmt.Errorf("module %s has no storage", moduleName)
+	}
+
+	var entry *MetadataStorageEntry
+	for _, item := range module.Storage.Entries {
+		if strings.EqualFold(item.Name, entryName) {
+			entry = item
+			break
+		}
+	}
+	if entry == nil {
+		return "", fmt.Errorf("storage %s.%s not found in metadata", moduleName, entryName)
+	}
+
+	if len(keys) != len(entry.Hashers) {
+		return "", fmt.Errorf("storage %s.%s expects %d keys, got %d", moduleName, entryName, len(entry.Hashers), len(keys))
+	}
+
+	key := make([]byte, 0)
+	key = append(key, Twox([]byte(module.Storage.Prefix), 16)...)
+	key = append(key, Twox([]byte(entry.Name), 16)...)
+
+	for i, hasher := range entry.Hashers {
+		hashed, err := StorageHash(hasher, keys[i])
+		if err != nil {
+			return "", err
+		}
+		key = append(key, hashed...)
+	}
+
+	return "0x" + hex.EncodeToString(key), nil
+}
+
+// DecodeStorageBalance 解析 u128 的余额，存储不存在时为 0
+func DecodeStorageBalance(value string) (*big.Int, error) {
+	value = strings.TrimPrefix(value, "0x")
+	if len(value) == 0 {
+		return big.NewInt(0), nil
+	}
+
+	d, err := NewScaleDecoderFromHex(value)
+	if err != nil {
+		return nil, errors.New("invalid balance storage")
+	}
+	return d.DecodeU128()
+}
+
+// DecodeStorageAccountNonce 解析 System.Account 中的 nonce，AccountInfo 的第一个字段为 u32 的 nonce
+func DecodeStorageAccountNonce(value string) (uint64, error) {
+	value = strings.TrimPrefix(value, "0x")
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) < 4 {
+		return 0, errors.New("invalid account storage")
+	}
+	return uint64(binary.LittleEndian.Uint32(data[:4])), nil
+}
+
+// EncodeAssetIdKey 编码存储键中的资产id
+func EncodeAssetIdKey(assetId uint64) []byte {
+	e := NewScaleEncoder()
+	e.EncodeU32(uint32(assetId))
+	return e.Bytes()
+}
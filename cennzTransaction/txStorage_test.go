@@ -0,0 +1,72 @@
+package cennzTransaction
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func Test_Twox(t *testing.T) {
+	testTable := []struct {
+		data   string
+		length int
+		hash   string
+	}{
+		{"", 8, "99e9d85137db46ef"},
+		{"System", 16, "26aa394eea5630e07c48ae0c9558cef7"},
+		{"Account", 16, "b99d880ec681799c0cf30e8886371da9"},
+		{"Number", 16, "02a5c1b19ab7a04f536c519aca4983ac"},
+	}
+
+	for _, item := range testTable {
+		hash := hex.EncodeToString(Twox([]byte(item.data), item.length))
+		if hash != item.hash {
+			t.Error("wrong twox hash of ", item.data, " : ", hash)
+		}
+	}
+}
+
+func Test_CreateStorageKey(t *testing.T) {
+	md, err := DecodeRuntimeMetadata(encodeTestMetadata(MetadataV12, testMetadataModules))
+	if err != nil {
+		t.Fatal("decode metadata failed : ", err)
+	}
+
+	key, err := md.CreateStorageKey("system", "Number")
+	if err != nil || key != "0x26aa394eea5630e07c48ae0c9558cef702a5c1b19ab7a04f536c519aca4983ac" {
+		t.Error("wrong plain storage key : ", key, err)
+	}
+
+	alice, _ := hex.DecodeString("d43593c715fdd31c61141abd04a99fd6822c8558854ccde39a5684e7a56da27d")
+	key, err = md.CreateStorageKey("genericAsset", "FreeBalance", EncodeAssetIdKey(1), alice)
+	if err != nil {
+		t.Fatal("create double map key failed : ", err)
+	}
+
+	prefix := hex.EncodeToString(Twox([]byte("GenericAsset"), 16)) + hex.EncodeToString(Twox([]byte("FreeBalance"), 16))
+	assetKey := hex.EncodeToString(Twox(EncodeAssetIdKey(1), 8)) + "01000000"
+	accountKey := "de1e86a9a8c739864cf3cc5ec2bea59f" + hex.EncodeToString(alice)
+	if key != "0x"+prefix+assetKey+accountKey {
+		t.Error("wrong double map storage key : ", key)
+	}
+
+	if _, err := md.CreateStorageKey("genericAsset", "FreeBalance", alice); err == nil {
+		t.Error("wrong key count not detected")
+	}
+}
+
+func Test_DecodeStorageValue(t *testing.T) {
+	balance, err := DecodeStorageBalance("0x00407a10f35a00000000000000000000")
+	if err != nil || balance.String() != "100000000000000" {
+		t.Error("wrong balance : ", balance, err)
+	}
+
+	balance, err = DecodeStorageBalance("")
+	if err != nil || balance.Sign() != 0 {
+		t.Error("empty balance should be zero : ", balance, err)
+	}
+
+	nonce, err := DecodeStorageAccountNonce("0x0500000001000000")
+	if err != nil || nonce != 5 {
+		t.Error("wrong nonce : ", nonce, err)
+	}
+}
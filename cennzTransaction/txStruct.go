@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"math/big"
 )
 
 type TxStruct struct {
@@ -12,27 +13,91 @@ type TxStruct struct {
 	//Version int `json:"version"`
 	SenderPubkey string `json:"sender_pubkey"`
 	RecipientPubkey string `json:"recipient_pubkey"`
-	Amount uint64 `json:"amount"`
+	Amount *big.Int `json:"amount"`
 	AssetId uint64 `json:"assetId"`
 	Nonce uint64 `json:"nonce"`
-	Tip uint64 `json:tip`
-	Fee uint64 `json:"fee"`
+	Tip uint64 `json:"tip"`
+	//使用其他资产支付手续费，为空时使用 CPAY
+	FeeExchange *FeeExchange `json:"fee_exchange,omitempty"`
 	BlockHeight uint64 `json:"block_height"`
+	Period uint64 `json:"period"`
 	BlockHash string `json:"block_hash"`
 	GenesisHash string `json:"genesis_hash"`
 	SpecVersion uint32 `json:"spec_version"`
 	TxVersion uint32 `json:"txVersion"`
+	SignatureType byte `json:"signature_type"`
+	CallIndex string `json:"call_index"`
+	//多笔转账，不为空时打包为 utility.batch 调用，忽略 RecipientPubkey、Amount 和 AssetId
+	Transfers []TransferItem `json:"transfers,omitempty"`
+	BatchCallIndex string `json:"batch_call_index,omitempty"`
 }
 
+type TransferItem struct {
+	RecipientPubkey string `json:"recipient_pubkey"`
+	Amount *big.Int `json:"amount"`
+	AssetId uint64 `json:"assetId"`
+}
 
-func (tx TxStruct) NewTxPayLoad() (*TxPayLoad, error) {
-	var tp TxPayLoad
-	method, err := NewMethodTransfer(tx.RecipientPubkey, tx.Amount, tx.AssetId)
+// 转账的调用序号，由元数据解析得到，未设置时使用默认值
+func (tx TxStruct) transferCode() string {
+	if tx.CallIndex == "" {
+		return Generic_Asset_Transfer
+	}
+	return tx.CallIndex
+}
+
+// getMethodBytes 编码交易调用，多笔转账时为 utility.batch(Vec<Call>)
+func (tx TxStruct) getMethodBytes(transferCode string) ([]byte, error) {
+	if len(tx.Transfers) == 0 {
+		method, err := NewMethodTransfer(tx.RecipientPubkey, tx.Amount, tx.AssetId)
+		if err != nil {
+			return nil, err
+		}
+		return method.ToBytes(transferCode)
+	}
+
+	batchCode, err := hex.DecodeString(tx.BatchCallIndex)
+	if err != nil || len(batchCode) != 2 {
+		return nil, errors.New("invalid batch call index")
+	}
+
+	e := NewScaleEncoder()
+	e.EncodeFixedBytes(batchCode)
+	err = e.EncodeVec(len(tx.Transfers), func(i int, e *ScaleEncoder) error {
+		transfer := tx.Transfers[i]
+		method, err := NewMethodTransfer(transfer.RecipientPubkey, transfer.Amount, transfer.AssetId)
+		if err != nil {
+			return err
+		}
+		methodBytes, err := method.ToBytes(transferCode)
+		if err != nil {
+			return err
+		}
+		e.EncodeFixedBytes(methodBytes)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	tp.Method, err = method.ToBytes(Generic_Asset_Transfer)
+	return e.Bytes(), nil
+}
+
+// getFeeExchangeBytes 编码 ChargeTransactionPayment 中的 Option<FeeExchange>
+func (tx TxStruct) getFeeExchangeBytes() ([]byte, error) {
+	e := NewScaleEncoder()
+	err := encodeFeeExchange(e, tx.FeeExchange)
+	if err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+func (tx TxStruct) NewTxPayLoad() (*TxPayLoad, error) {
+	var tp TxPayLoad
+	var err error
+
+	tp.Method, err = tx.getMethodBytes(tx.transferCode())
 	if err != nil {
 		return  nil, err
 	}
@@ -41,28 +106,21 @@ func (tx TxStruct) NewTxPayLoad() (*TxPayLoad, error) {
 		return nil, errors.New("invalid block height")
 	}
 
-	tp.Era = GetEra(tx.BlockHeight)
+	tp.Era = GetEra(tx.BlockHeight, tx.Period)
 
-	if tx.Nonce == 0 {
-		tp.Nonce = []byte{0}
-	} else {
-		nonce := Encode(uint64(tx.Nonce))
-		tp.Nonce, _ = hex.DecodeString(nonce)
+	tp.Nonce, err = CompactBytes(tx.Nonce)
+	if err != nil {
+		return nil, err
 	}
 
-	if tx.Tip == 0 {
-		tp.Tip = []byte{0}
-	} else {
-		tip := Encode(uint64(tx.Tip))
-		tp.Tip, _ = hex.DecodeString(tip)
+	tp.Tip, err = CompactBytes(tx.Tip)
+	if err != nil {
+		return nil, err
 	}
 
-	if tx.Fee == 0 {
-		//return nil, errors.New("a none zero fee must be payed")
-		tp.Fee = []byte{0}
-	} else {
-		fee := Encode( uint64(tx.Fee))
-		tp.Fee, _ = hex.DecodeString(fee)
+	tp.Fee, err = tx.getFeeExchangeBytes()
+	if err != nil {
+		return nil, err
 	}
 
 	specv := make([]byte, 4)
@@ -126,7 +184,10 @@ func (ts TxStruct) GetSignedTransaction (transfer_code, signature string) (strin
 
 	signed = append(signed, from...)
 
-	signed = append(signed, 0x00) // ed25519
+	if ts.SignatureType != SignatureTypeEd25519 && ts.SignatureType != SignatureTypeSr25519 {
+		return "", errors.New("unsupported signature type")
+	}
+	signed = append(signed, ts.SignatureType)
 
 	sig, err := hex.DecodeString(signature)
 	if err != nil || len(sig) != 64 {
@@ -138,53 +199,37 @@ func (ts TxStruct) GetSignedTransaction (transfer_code, signature string) (strin
 		return "", errors.New("invalid block height")
 	}
 
-	signed = append(signed, GetEra(ts.BlockHeight)...)
-
-	if ts.Nonce == 0 {
-		signed = append(signed, 0)
-	} else {
-		nonce:= Encode( uint64(ts.Nonce))
-
-		nonceBytes, _ := hex.DecodeString(nonce)
-		signed = append(signed, nonceBytes...)
-	}
-
-	if ts.Tip == 0 {
-		signed = append(signed, 0)
-	} else {
-		tip := Encode( uint64(ts.Tip))
+	signed = append(signed, GetEra(ts.BlockHeight, ts.Period)...)
 
-		tipBytes, _ := hex.DecodeString(tip)
-		signed = append(signed, tipBytes...)
+	nonceBytes, err := CompactBytes(ts.Nonce)
+	if err != nil {
+		return "", err
 	}
+	signed = append(signed, nonceBytes...)
 
-	feeBytes := make([]byte, 0)
-	if ts.Fee == 0 {
-		//return "", errors.New("a none zero fee must be payed")
-		feeBytes = []byte{0}
-	} else {
-		fee := Encode(uint64(ts.Fee))
-		feeBytes, _ = hex.DecodeString(fee)
+	tipBytes, err := CompactBytes(ts.Tip)
+	if err != nil {
+		return "", err
 	}
+	signed = append(signed, tipBytes...)
 
-	signed = append(signed, feeBytes...)
-
-	method, err := NewMethodTransfer(ts.RecipientPubkey, ts.Amount, ts.AssetId)
+	feeBytes, err := ts.getFeeExchangeBytes()
 	if err != nil {
 		return "", err
 	}
 
-	methodBytes, err := method.ToBytes(transfer_code)
+	signed = append(signed, feeBytes...)
+
+	methodBytes, err := ts.getMethodBytes(transfer_code)
 	if err != nil {
 		return "", err
 	}
 
 	signed = append(signed, methodBytes...)
 
-	length := Encode(uint64(len(signed)))
+	lengthBytes, err := CompactBytes(uint64(len(signed)))
 	if err != nil {
 		return "", err
 	}
-	lengthBytes, _ := hex.DecodeString(length)
 	return "0x" + hex.EncodeToString(lengthBytes) + hex.EncodeToString(signed), nil
 }
\ No newline at end of file
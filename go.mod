@@ -3,6 +3,7 @@ module github.com/blocktree/cennz-adapter
 go 1.12
 
 require (
+	github.com/ChainSafe/go-schnorrkel v1.0.0
 	github.com/asdine/storm v2.1.2+incompatible
 	github.com/astaxie/beego v1.12.0
 	github.com/blocktree/go-owcdrivers v1.2.23
@@ -10,6 +11,7 @@ require (
 	github.com/blocktree/openwallet v1.5.4
 	github.com/blocktree/openwallet/v2 v2.0.2
 	github.com/ethereum/go-ethereum v1.9.9
+	github.com/gorilla/websocket v1.4.2
 	github.com/imroc/req v0.2.4
 	github.com/pborman/uuid v1.2.0
 	github.com/prometheus/common v0.6.0